@@ -0,0 +1,106 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shipa988/fanouter/internal/data/repository"
+)
+
+var addr string
+
+// dlqCmd represents the dlq command
+var dlqCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "dead letters",
+	Long:  `dlq lists, replays and purges queries which could not be delivered to external urls`,
+}
+
+var dlqListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list dead letters",
+	Long:  `list prints stored dead letters as json lines`,
+	Run: func(cmd *cobra.Command, args []string) {
+		letters, err := dlqRepo().List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, letter := range letters {
+			if err := enc.Encode(letter); err != nil {
+				log.Fatal(err)
+			}
+		}
+	},
+}
+
+var dlqReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "replay dead letters",
+	Long:  `replay asks running fanouter to queue dead letters again to limiters of their feeds`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if addr == "" {
+			addr = net.JoinHostPort("localhost", cfg.API.HTTPPort)
+		}
+		resp, err := http.Post("http://"+addr+"/admin/dlq/replay", "application/json", nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("fanouter answered %v: %s", resp.Status, body)
+		}
+		fmt.Println(string(body))
+	},
+}
+
+var dlqPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "purge dead letters",
+	Long:  `purge deletes all stored dead letters`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := dlqRepo().Purge(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func dlqRepo() *repository.DLQRepo {
+	if cfg.DLQ.Path == "" {
+		log.Fatal("dlq path is not set in config")
+	}
+	return repository.NewDLQRepo(cfg.DLQ.Path)
+}
+
+func init() {
+	rootCmd.AddCommand(dlqCmd)
+	dlqCmd.AddCommand(dlqListCmd, dlqReplayCmd, dlqPurgeCmd)
+
+	dlqReplayCmd.Flags().StringVar(&addr, "addr", "", "address of running fanouter (default is localhost:api.httpport of config)")
+}
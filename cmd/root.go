@@ -27,9 +27,10 @@ import (
 )
 
 var (
-	cfgFile string
-	debug   bool
-	cfg     *app.Config
+	cfgFile  string
+	urlsPath string
+	debug    bool
+	cfg      *app.Config
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -50,6 +51,7 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", `config\config.yaml`, "config file (default is $./config/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&urlsPath, "urls", "", "path of urls json (default is urlrepo.path of config)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -66,15 +68,21 @@ func initConfig() {
 
 	viper.AutomaticEnv() // read in environment variables that match
 	// If a config file is found, read it in.
+	cfg = &app.Config{}
 	if err := viper.ReadInConfig(); err != nil {
+		if urlsPath != "" { // validate of given urls json doesn't need config
+			cfg.URLRepo.Path = urlsPath
+			return
+		}
 		log.Fatal(err)
 	}
-	cfg = &app.Config{}
 	err := viper.Unmarshal(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-
+	if urlsPath != "" {
+		cfg.URLRepo.Path = urlsPath
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
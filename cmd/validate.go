@@ -0,0 +1,56 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/shipa988/fanouter/internal/data/app"
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate urls json",
+	Long: `validate checks fanout parameters of urls json and prints all problems with json paths of invalid values,
+durable queues of feeds are valid if queue.dir is set in config`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := cfg.URLRepo.Path
+		_, err := fanouter.Load(repository.NewJSONRepo(path), app.NewLimiterFabrics(cfg, nil))
+		if perr, ok := errors.Cause(err).(*entity.ParamError); ok {
+			for _, p := range perr.Problems {
+				fmt.Printf("%v: %v\n", p.Path, p.Message)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v is valid\n", path)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
@@ -2,16 +2,23 @@ package app
 
 import (
 	"context"
+	"database/sql"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
+	_ "github.com/mattn/go-sqlite3" //driver of fanout parameters database
 	"github.com/pkg/errors"
 
 	"github.com/shipa988/fanouter/internal/data/controller"
 	"github.com/shipa988/fanouter/internal/data/logger/zerologger"
+	"github.com/shipa988/fanouter/internal/data/metrics/prommetrics"
 	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase"
 	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
 	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
 )
@@ -34,18 +41,49 @@ func (a *App) Start(cfg *Config, debug bool) (err error) {
 		}
 	}
 
-	logger := zerologger.NewLogger(wr, debug)           //for logging
-	urlRepo := repository.NewJSONRepo(cfg.URLRepo.Path) //for loading fanout parameters
-	senderFabric := controllers.NewHTTPClientFabric()   //senders creating inside fanOuter
-	qpsLimiterFabric := limiter.NewCLimiterFabric()     //limiters creating inside fanOuter
+	logger := zerologger.NewLogger(wr, debug)         //for logging
+	senderFabric := controllers.NewHTTPClientFabric() //senders creating inside fanOuter
+	metrics := prommetrics.NewMetrics()               //for metrics endpoint
 
-	fanOuter := fanouter.NewFanoutInteractor(urlRepo, senderFabric, qpsLimiterFabric, logger)
+	var urlRepo entity.FanParamRepo = repository.NewJSONRepo(cfg.URLRepo.Path) //for loading fanout parameters
+	if cfg.URLRepo.Driver != "" {
+		db, err := sql.Open(cfg.URLRepo.Driver, cfg.URLRepo.DSN)
+		if err != nil {
+			cancel()
+			return errors.Wrapf(err, "can't open fanout parameters database")
+		}
+		defer db.Close()
+		sqlRepo := repository.NewSQLRepo(db)
+		if err := sqlRepo.Migrate(); err != nil {
+			cancel()
+			return errors.Wrapf(err, "can't start app")
+		}
+		urlRepo = sqlRepo
+	}
+
+	var store limiter.Store //for limits shared by replicas
+	if cfg.Cluster.Redis != "" {
+		store = repository.NewRedisStore(cfg.Cluster.Redis, cfg.Cluster.Password, cfg.Cluster.DB)
+	}
+	qpsLimiterFabric := NewLimiterFabrics(cfg, store) //limiters creating inside fanOuter
+
+	fanOuter := fanouter.NewFanoutInteractor(urlRepo, senderFabric, qpsLimiterFabric, logger, metrics)
+	if cfg.DLQ.Path != "" {
+		fanOuter.WithDeadLetters(repository.NewDLQRepo(cfg.DLQ.Path)) //for undeliverable queries
+	}
+	if cfg.Quota.Path != "" {
+		fanOuter.WithQuotas(repository.NewQuotaRepo(cfg.Quota.Path)) //for quota usage surviving restart
+	}
 	err = fanOuter.Init(ctx)
 	if err != nil {
 		cancel()
 		return errors.Wrapf(err, "can't start app")
 	}
-	server := controllers.NewHttpServer(net.JoinHostPort("0.0.0.0", cfg.API.HTTPPort), logger, fanOuter)
+	var handler fanouter.Fanouter = fanOuter
+	if cfg.Idempotency.TTL > 0 { //for suppressing duplicates of incoming queries
+		handler = fanouter.NewIdempotentFanouter(fanOuter, cfg.Idempotency.Param, time.Duration(cfg.Idempotency.TTL)*time.Second)
+	}
+	server := controllers.NewHttpServer(net.JoinHostPort("0.0.0.0", cfg.API.HTTPPort), logger, handler, metrics.Handler())
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
@@ -55,11 +93,53 @@ func (a *App) Start(cfg *Config, debug bool) (err error) {
 			logger.Log(ctx, errors.Wrapf(err, "can't start http server"))
 		}
 	}()
+
+	var changes <-chan struct{}
+	if watcher, ok := urlRepo.(entity.FanParamWatcher); ok {
+		changes, err = watcher.Watch(ctx) //reload fanout parameters after changing of json
+		if err != nil {
+			logger.Log(ctx, err)
+		}
+	}
+	hup := make(chan os.Signal, 1) //reload fanout parameters by SIGHUP
+	signal.Notify(hup, syscall.SIGHUP)
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
-	<-c
+loop:
+	for {
+		select {
+		case <-c:
+			break loop
+		case <-hup:
+			a.reload(ctx, logger, fanOuter)
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			a.reload(ctx, logger, fanOuter)
+		}
+	}
 	cancel()
 	server.StopServe()
 	wg.Wait()
+	fanOuter.Wait() //for saving quota usage on stop
 	return nil
 }
+
+// NewLimiterFabrics returns fabrics of limiters of all algorithms and of durable limiters if queue dir is configured,
+// distributed limiters share limits by store (nil if there is no store).
+func NewLimiterFabrics(cfg *Config, store limiter.Store) *limiter.Fabrics {
+	fabrics := limiter.NewDefaultFabrics().
+		Register(limiter.AlgorithmDistributed, limiter.NewDistLimiterFabric(store, cfg.Cluster.Replicas))
+	if cfg.Queue.Dir != "" {
+		fabrics.WithJournals(repository.NewWALFabric(cfg.Queue.Dir)) //for durable queues of feeds
+	}
+	return fabrics
+}
+
+func (a *App) reload(ctx context.Context, logger usecase.Logger, fanOuter fanouter.Fanouter) {
+	if err := fanOuter.Reload(ctx); err != nil {
+		logger.Log(ctx, errors.Wrapf(err, "can't reload fanout parameters"))
+	}
+}
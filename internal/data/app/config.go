@@ -4,6 +4,12 @@ type Config struct {
 	Log     Log     `yaml:"log"`
 	URLRepo URLRepo `yaml:"urlrepo"`
 	API     API     `yaml:"api"`
+	Queue   Queue   `yaml:"queue"`
+	DLQ     DLQ     `yaml:"dlq"`
+	Quota   Quota   `yaml:"quota"`
+	// Idempotency is deduplication of incoming queries by idempotency key, it is off if ttl is not set.
+	Idempotency Idempotency `yaml:"idempotency"`
+	Cluster     Cluster     `yaml:"cluster"`
 }
 
 type Log struct {
@@ -14,6 +20,40 @@ type API struct {
 	HTTPPort string `yaml:"httpport"`
 }
 
+// URLRepo is store of fanout parameters: database of database/sql driver (sqlite3) if driver is set,
+// json file otherwise.
 type URLRepo struct {
+	Path   string `yaml:"path"`
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"` // data source name of database
+}
+
+// Queue is parameters of durable queues of feeds.
+type Queue struct {
+	Dir string `yaml:"dir"`
+}
+
+// DLQ is parameters of dead letters store.
+type DLQ struct {
 	Path string `yaml:"path"`
 }
+
+// Quota is parameters of quota usage store.
+type Quota struct {
+	Path string `yaml:"path"`
+}
+
+// Idempotency is parameters of deduplication of incoming queries.
+type Idempotency struct {
+	Param string `yaml:"param"` // query param with idempotency key used if there is no Idempotency-Key header
+	TTL   int    `yaml:"ttl"`   // seconds
+}
+
+// Cluster is parameters of replicas sharing limits of distributed algorithm. Without redis address every replica
+// sends limit divided by count of replicas.
+type Cluster struct {
+	Replicas int    `yaml:"replicas"`
+	Redis    string `yaml:"redis"` // address of redis storing shared logs
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
@@ -4,18 +4,24 @@ import (
 	"bytes"
 	"context"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/shipa988/fanouter/internal/domain/entity"
 	"github.com/shipa988/fanouter/internal/domain/usecase"
 	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
+	"github.com/shipa988/fanouter/internal/util"
 )
 
 const (
 	ErrSend    = "can't send request to url %v"
 	ErrRequest = "can't create request to url %v"
+	ErrRetry   = "can't retry request to url %v"
+	ErrStatus  = "url %v answered with status %v"
+	ErrLetter  = "can't store dead letter of request to url %v"
 )
 
 const (
@@ -26,34 +32,59 @@ const (
 var _ sender.QuerySender = (*HTTPClient)(nil)
 
 type HTTPClient struct {
-	clients []*http.Client
-	logger  usecase.Logger
+	clients  []*http.Client
+	logger   usecase.Logger
+	metrics  usecase.Metrics
+	requeue  func(ctx context.Context, msg entity.Message) error
+	url      entity.URL
+	builder  *sender.RequestBuilder
+	retry    *sender.RetryPolicy
+	breaker  *sender.Breaker
+	adaptive *sender.Adaptive
+	letters  entity.DeadLetterRepo
 }
 
-func (c *HTTPClient) Init(timeout time.Duration, poolSize int, logger usecase.Logger) {
+func (c *HTTPClient) Init(opts sender.Options) {
 	tr := &http.Transport{
-		MaxIdleConns:    poolSize / 2,
-		MaxConnsPerHost: poolSize,
+		MaxIdleConns:    opts.PoolSize / 2,
+		MaxConnsPerHost: opts.PoolSize,
 	}
-	for i := 0; i < poolSize; i++ {
+	for i := 0; i < opts.PoolSize; i++ {
 		client := &http.Client{
 			Transport: tr,
-			Timeout:   timeout * time.Second,
+			Timeout:   opts.Timeout,
 		}
 		c.clients = append(c.clients, client)
 	}
-	c.logger = logger
+	c.logger = opts.Logger
+	c.metrics = opts.Metrics
+	c.requeue = opts.Requeue
+	c.breaker = opts.Breaker
+	c.adaptive = opts.Adaptive
+	c.letters = opts.DeadLetters
 }
 
-func (c *HTTPClient) Send(ctx context.Context, url string, in <-chan string) {
-	c.logger.Log(ctx, StartClient, url)
-	defer c.logger.Log(ctx, StopClient, url)
+func (c *HTTPClient) Send(ctx context.Context, url entity.URL, in <-chan entity.Message) {
+	c.logger.Log(ctx, StartClient, url.Value)
+	defer c.logger.Log(ctx, StopClient, url.Value)
+	builder, err := sender.NewRequestBuilder(url)
+	if err != nil {
+		err = errors.Wrapf(err, ErrRequest, url.Value)
+		c.logger.Log(ctx, err)
+		c.url = url
+		c.drop(ctx, in, err)
+		return
+	}
+	c.url, c.builder, c.retry = url, builder, sender.NewRetryPolicy(url.Retry)
 	wg := &sync.WaitGroup{}
 	for _, client := range c.clients {
 		wg.Add(1)
 		go func(cl *http.Client) {
 			defer wg.Done()
-			var s string
+			var (
+				msg entity.Message
+				ok  bool
+			)
 			for {
 				select {
 				case <-ctx.Done():
@@ -63,26 +94,179 @@ func (c *HTTPClient) Send(ctx context.Context, url string, in <-chan string) {
 				select {
 				case <-ctx.Done():
 					return
-				case s = <-in:
-					req, err := http.NewRequest( //todo:reuse the request
-						"GET", url, bytes.NewBuffer([]byte(s)),
-					)
-					if err != nil {
-						c.logger.Log(ctx, errors.Wrapf(err, ErrRequest, url))
-					}
-					req = req.WithContext(ctx)
-
-					b, err := cl.Do(req) //todo:reuse the request
-					if err != nil {
-						c.logger.Log(ctx, errors.Wrapf(err, ErrSend, url))
+				case msg, ok = <-in:
+					if !ok { // input closed: sender stops after sending all queries
+						return
 					}
-					if b != nil {
-						b.Body.Close()
-					}
-				default:
+					c.send(ctx, cl, msg)
 				}
 			}
 		}(client)
 	}
 	wg.Wait()
 }
+
+// send sends message to url if breaker allows, failed retryable request is queued again to limiter after backoff,
+// request which can't be delivered is stored as dead letter.
+func (c *HTTPClient) send(ctx context.Context, cl *http.Client, msg entity.Message) {
+	mctx := context.WithValue(ctx, util.RequestID, msg.RequestID) //log with id of incoming query
+	r, err := c.builder.Build(msg, time.Now())
+	if err != nil {
+		err = errors.Wrapf(err, ErrRequest, c.url.Value)
+		c.logger.Log(mctx, err)
+		c.deadLetter(mctx, msg, nil, err)
+		c.reply(msg, 0, 0, err)
+		return
+	}
+	req, err := newRequest(mctx, r)
+	if err != nil {
+		err = errors.Wrapf(err, ErrRequest, c.url.Value)
+		c.logger.Log(mctx, err)
+		c.deadLetter(mctx, msg, &r, err)
+		c.reply(msg, 0, 0, err)
+		return
+	}
+
+	var (
+		code    int
+		wait    time.Duration
+		latency time.Duration
+	)
+	if c.breaker.Allow() {
+		start := time.Now()
+		code, wait, err = c.do(mctx, cl, req, msg)
+		latency = time.Since(start)
+		c.breaker.Done(err == nil && code < http.StatusInternalServerError)
+		c.adaptive.Done(code, wait, latency)
+	} else {
+		err = sender.ErrBreakerOpen
+		c.metrics.ShortCircuit(c.url.ID, msg.FeedID)
+	}
+	msg.Attempt++
+	if ctx.Err() != nil || !c.retry.Retryable(msg.Attempt, code, err) { // stopped sender doesn't retry
+		c.finish(mctx, msg, &r, code, latency, err)
+		return
+	}
+	time.AfterFunc(c.retry.Backoff(msg.Attempt, wait), func() {
+		if ctx.Err() != nil { // sender is stopped while retry waits
+			c.finish(mctx, msg, &r, code, latency, err)
+			return
+		}
+		c.metrics.Retry(c.url.ID, msg.FeedID)
+		if err := c.requeue(ctx, msg); err != nil {
+			err = errors.Wrapf(err, ErrRetry, c.url.Value)
+			c.logger.Log(mctx, err)
+			c.deadLetter(mctx, msg, &r, err)
+			c.reply(msg, code, latency, err)
+		}
+	})
+}
+
+// finish ends sending of message with result of its last attempt: failed message is stored as dead letter,
+// the result is sent to synchronous fanout waiting for it.
+func (c *HTTPClient) finish(ctx context.Context, msg entity.Message, r *sender.Request, code int, latency time.Duration, err error) {
+	if err == nil && (code < http.StatusOK || code >= http.StatusMultipleChoices) {
+		err = errors.Errorf(ErrStatus, c.url.Value, code)
+	}
+	if err != nil {
+		c.deadLetter(ctx, msg, r, err)
+	}
+	c.reply(msg, code, latency, err)
+}
+
+// reply sends final result of sending message to url to synchronous fanout waiting for it.
+func (c *HTTPClient) reply(msg entity.Message, code int, latency time.Duration, err error) {
+	if msg.Reply == nil {
+		return
+	}
+	result := entity.Result{URLID: c.url.ID, Code: code, Latency: float64(latency) / float64(time.Millisecond)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	msg.Reply(result)
+}
+
+// deadLetter stores message which can't be delivered with its last request (nil if it could not be built).
+func (c *HTTPClient) deadLetter(ctx context.Context, msg entity.Message, r *sender.Request, err error) {
+	if c.letters == nil {
+		return
+	}
+	letter := entity.DeadLetter{
+		URLID:    c.url.ID,
+		FeedID:   msg.FeedID,
+		Attempts: msg.Attempt,
+		Error:    err.Error(),
+		Time:     time.Now(),
+		Message:  msg,
+	}
+	if r != nil {
+		letter.Method, letter.URL, letter.Header, letter.Body = r.Method, r.URL, r.Header, r.Body
+	}
+	if err := c.letters.Put(letter); err != nil {
+		c.logger.Log(ctx, errors.Wrapf(err, ErrLetter, c.url.Value))
+		return
+	}
+	c.metrics.DeadLetter(c.url.ID, msg.FeedID)
+}
+
+// do sends request, returns status code and delay asked by Retry-After header of response.
+func (c *HTTPClient) do(ctx context.Context, cl *http.Client, req *http.Request, msg entity.Message) (code int, wait time.Duration, err error) {
+	start := time.Now()
+	b, err := cl.Do(req) //todo:reuse the request
+	if err != nil {
+		c.logger.Log(ctx, errors.Wrapf(err, ErrSend, c.url.Value))
+	}
+	if b != nil {
+		code = b.StatusCode
+		wait = retryAfterHeader(b)
+		b.Body.Close()
+	}
+	c.metrics.Request(c.url.ID, msg.FeedID, code, time.Since(start))
+	if err == nil && code >= http.StatusBadRequest {
+		c.logger.Log(ctx, ErrStatus, c.url.Value, code)
+	}
+	return code, wait, err
+}
+
+// retryAfterHeader returns delay asked by Retry-After header of response in seconds or http date format.
+func retryAfterHeader(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(value); err == nil {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// newRequest creates http request to url from outgoing query.
+func newRequest(ctx context.Context, r sender.Request) (*http.Request, error) {
+	req, err := http.NewRequest(r.Method, r.URL, bytes.NewReader(r.Body)) //todo:reuse the request
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+	return req.WithContext(ctx), nil
+}
+
+// drop reads queries which can't be sent until input is closed and stores them as dead letters.
+func (c *HTTPClient) drop(ctx context.Context, in <-chan entity.Message, err error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			c.deadLetter(ctx, msg, nil, err)
+			c.reply(msg, 0, 0, err)
+		}
+	}
+}
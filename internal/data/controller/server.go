@@ -3,36 +3,50 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 
+	"github.com/shipa988/fanouter/internal/domain/entity"
 	"github.com/shipa988/fanouter/internal/domain/usecase"
 	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
 	"github.com/shipa988/fanouter/internal/util"
 )
 
 const (
-	ErrID = "must be id in query"
+	ErrID    = "must be id in query"
+	ErrLimit = "must be positive integer limit in body"
+	ErrBody  = "can't read body"
+	ErrJSON  = "must be json of %v in body"
 )
 const (
 	MainAnswer = `go to /feeds/{id}`
 )
 
+// maxBodySize is max size of incoming query body forwarded to external urls.
+const maxBodySize = 1 << 20
+
 type HTTPServer struct {
 	logger   usecase.Logger
 	server   *http.Server
 	fanouter fanouter.Fanouter
+	metrics  http.Handler
 }
 
-func NewHttpServer(addr string, logger usecase.Logger, fanouter fanouter.Fanouter) *HTTPServer {
+func NewHttpServer(addr string, logger usecase.Logger, fanouter fanouter.Fanouter, metrics http.Handler) *HTTPServer {
 	server := &http.Server{Addr: addr}
 	return &HTTPServer{
 		server:   server,
 		logger:   logger,
 		fanouter: fanouter,
+		metrics:  metrics,
 	}
 }
 
@@ -42,7 +56,23 @@ func (s *HTTPServer) Serve() error {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/", s.main).Methods(http.MethodGet)
-	router.HandleFunc("/feeds/{id}", s.fanout).Methods(http.MethodGet)
+	router.HandleFunc("/feeds/{id}", s.fanout).Methods(http.MethodGet, http.MethodPost, http.MethodPut)
+	router.HandleFunc("/admin/urls/{urlID}/feeds/{feedID}/limit", s.setLimit).Methods(http.MethodPut)
+	router.HandleFunc("/admin/reload", s.reload).Methods(http.MethodPost)
+	router.HandleFunc("/admin/urls", s.urls).Methods(http.MethodGet)
+	router.HandleFunc("/admin/urls", s.createURL).Methods(http.MethodPost)
+	router.HandleFunc("/admin/urls/{urlID}", s.url).Methods(http.MethodGet)
+	router.HandleFunc("/admin/urls/{urlID}", s.updateURL).Methods(http.MethodPut)
+	router.HandleFunc("/admin/urls/{urlID}", s.deleteURL).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/urls/{urlID}/feeds", s.feeds).Methods(http.MethodGet)
+	router.HandleFunc("/admin/urls/{urlID}/feeds", s.createFeed).Methods(http.MethodPost)
+	router.HandleFunc("/admin/urls/{urlID}/feeds/{feedID}", s.updateFeed).Methods(http.MethodPut)
+	router.HandleFunc("/admin/urls/{urlID}/feeds/{feedID}", s.deleteFeed).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/urls/{urlID}/breaker", s.breaker).Methods(http.MethodGet)
+	router.HandleFunc("/admin/urls/{urlID}/rate", s.rate).Methods(http.MethodGet)
+	router.HandleFunc("/admin/quotas", s.quotas).Methods(http.MethodGet)
+	router.HandleFunc("/admin/dlq/replay", s.replayDeadLetters).Methods(http.MethodPost)
+	router.Handle("/metrics", s.metrics).Methods(http.MethodGet)
 
 	handler := s.accessLogMiddleware(router)
 	handler = s.panicMiddleware(handler)
@@ -65,14 +95,246 @@ func (s *HTTPServer) fanout(w http.ResponseWriter, r *http.Request) {
 		s.httpError(r.Context(), w, ErrID, http.StatusBadRequest)
 		return
 	}
-	err := s.fanouter.Fanout(r.Context(), id)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		s.httpError(r.Context(), w, ErrBody, http.StatusBadRequest)
+		return
+	}
+	result, err := s.fanouter.Fanout(r.Context(), entity.Message{
+		RequestID: util.GetRequestID(r.Context()),
+		FeedID:    id,
+		Method:    r.Method,
+		Header:    r.Header,
+		Query:     r.URL.Query(),
+		Body:      body,
+	})
+	switch e := errors.Cause(err).(type) {
+	case *limiter.OverflowError:
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(e.RetryAfter)))
+		s.httpError(r.Context(), w, err.Error(), http.StatusTooManyRequests)
+		return
+	case *limiter.QuotaError:
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(e.RetryAfter)))
+		s.httpError(r.Context(), w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		s.httpError(r.Context(), w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if result != nil { // synchronous feed: results of urls are answered
+		code := http.StatusOK
+		if !result.Success {
+			code = http.StatusBadGateway
+		}
+		s.httpAnswer(w, result, code)
+		return
+	}
 	s.httpAnswer(w, "query send", http.StatusOK)
 }
 
+type limitRequest struct {
+	Limit int `json:"limit"`
+}
+
+func (s *HTTPServer) setLimit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	lr := limitRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&lr); err != nil || lr.Limit <= 0 {
+		s.httpError(r.Context(), w, ErrLimit, http.StatusBadRequest)
+		return
+	}
+	err := s.fanouter.SetLimit(r.Context(), vars["urlID"], vars["feedID"], lr.Limit)
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "limit changed", http.StatusOK)
+}
+
+func (s *HTTPServer) reload(w http.ResponseWriter, r *http.Request) {
+	if err := s.fanouter.Reload(r.Context()); err != nil {
+		s.httpError(r.Context(), w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.httpAnswer(w, "parameters reloaded", http.StatusOK)
+}
+
+func (s *HTTPServer) urls(w http.ResponseWriter, r *http.Request) {
+	urls, err := s.fanouter.URLs(r.Context())
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.httpAnswer(w, urls, http.StatusOK)
+}
+
+func (s *HTTPServer) url(w http.ResponseWriter, r *http.Request) {
+	url, err := s.fanouter.URL(r.Context(), mux.Vars(r)["urlID"])
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, url, http.StatusOK)
+}
+
+func (s *HTTPServer) createURL(w http.ResponseWriter, r *http.Request) {
+	url := entity.URL{}
+	if err := decodeStrict(r, &url); err != nil {
+		s.httpError(r.Context(), w, fmt.Sprintf(ErrJSON, "url")+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.fanouter.CreateURL(r.Context(), url); err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "url created", http.StatusCreated)
+}
+
+// updateURL replaces url with id of path, its feeds are kept if body has no feeds.
+func (s *HTTPServer) updateURL(w http.ResponseWriter, r *http.Request) {
+	url := entity.URL{}
+	if err := decodeStrict(r, &url); err != nil {
+		s.httpError(r.Context(), w, fmt.Sprintf(ErrJSON, "url")+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	url.ID = mux.Vars(r)["urlID"]
+	if err := s.fanouter.UpdateURL(r.Context(), url); err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "url updated", http.StatusOK)
+}
+
+func (s *HTTPServer) deleteURL(w http.ResponseWriter, r *http.Request) {
+	if err := s.fanouter.DeleteURL(r.Context(), mux.Vars(r)["urlID"]); err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "url deleted", http.StatusOK)
+}
+
+func (s *HTTPServer) feeds(w http.ResponseWriter, r *http.Request) {
+	url, err := s.fanouter.URL(r.Context(), mux.Vars(r)["urlID"])
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	feeds := url.Feeds
+	if feeds == nil {
+		feeds = []entity.Feed{}
+	}
+	s.httpAnswer(w, feeds, http.StatusOK)
+}
+
+func (s *HTTPServer) createFeed(w http.ResponseWriter, r *http.Request) {
+	feed := entity.Feed{}
+	if err := decodeStrict(r, &feed); err != nil {
+		s.httpError(r.Context(), w, fmt.Sprintf(ErrJSON, "feed")+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.fanouter.CreateFeed(r.Context(), mux.Vars(r)["urlID"], feed); err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "feed created", http.StatusCreated)
+}
+
+func (s *HTTPServer) updateFeed(w http.ResponseWriter, r *http.Request) {
+	feed := entity.Feed{}
+	if err := decodeStrict(r, &feed); err != nil {
+		s.httpError(r.Context(), w, fmt.Sprintf(ErrJSON, "feed")+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	vars := mux.Vars(r)
+	feed.ID = vars["feedID"]
+	if err := s.fanouter.UpdateFeed(r.Context(), vars["urlID"], feed); err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "feed updated", http.StatusOK)
+}
+
+func (s *HTTPServer) deleteFeed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := s.fanouter.DeleteFeed(r.Context(), vars["urlID"], vars["feedID"]); err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, "feed deleted", http.StatusOK)
+}
+
+func (s *HTTPServer) breaker(w http.ResponseWriter, r *http.Request) {
+	status, err := s.fanouter.Breaker(r.Context(), mux.Vars(r)["urlID"])
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, status, http.StatusOK)
+}
+
+func (s *HTTPServer) rate(w http.ResponseWriter, r *http.Request) {
+	rate, err := s.fanouter.Rate(r.Context(), mux.Vars(r)["urlID"])
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, rate, http.StatusOK)
+}
+
+func (s *HTTPServer) quotas(w http.ResponseWriter, r *http.Request) {
+	quotas, err := s.fanouter.Quotas(r.Context())
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, quotas, http.StatusOK)
+}
+
+// ReplayAnswer is answer of dead letters replay.
+type ReplayAnswer struct {
+	Replayed int `json:"replayed"`
+}
+
+func (s *HTTPServer) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := s.fanouter.ReplayDeadLetters(r.Context())
+	if err != nil {
+		s.httpError(r.Context(), w, err.Error(), errorCode(err))
+		return
+	}
+	s.httpAnswer(w, ReplayAnswer{Replayed: n}, http.StatusOK)
+}
+
+// decodeStrict decodes json body into v, unknown fields are errors.
+func decodeStrict(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// retryAfter returns value of Retry-After header: whole seconds, at least one.
+func retryAfter(d time.Duration) int {
+	sec := int(math.Ceil(d.Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	return sec
+}
+
+// errorCode maps domain errors to http status codes.
+func errorCode(err error) int {
+	switch errors.Cause(err) {
+	case fanouter.ErrNotFound:
+		return http.StatusNotFound
+	case entity.ErrExists:
+		return http.StatusConflict
+	case fanouter.ErrReadOnly:
+		return http.StatusMethodNotAllowed
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 func (s *HTTPServer) StopServe() {
 	ctx := context.Background()
 	s.logger.Log(ctx, "stopping http server")
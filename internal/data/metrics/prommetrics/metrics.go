@@ -0,0 +1,244 @@
+package prommetrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/shipa988/fanouter/internal/domain/usecase"
+	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
+)
+
+const namespace = "fanouter"
+
+var _ usecase.Metrics = (*Metrics)(nil)
+
+// Metrics collects metrics in own prometheus registry.
+type Metrics struct {
+	registry  *prometheus.Registry
+	fanouts   *prometheus.CounterVec
+	enqueued  *prometheus.CounterVec
+	dropped   *prometheus.CounterVec
+	depth     *prometheus.GaugeVec
+	limit     *prometheus.GaugeVec
+	sent      *prometheus.CounterVec
+	achieved  *achievedQPS
+	retries   *prometheus.CounterVec
+	shorts    *prometheus.CounterVec
+	dead      *prometheus.CounterVec
+	quotas    *prometheus.GaugeVec
+	breakers  *prometheus.GaugeVec
+	requests  *prometheus.CounterVec
+	latencies *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		fanouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "fanout_total", Help: "Incoming fanout queries.",
+		}, []string{"feed"}),
+		enqueued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "limiter", Name: "enqueued_total", Help: "Queries queued by limiter.",
+		}, []string{"url", "feed"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "limiter", Name: "dropped_total", Help: "Queries dropped or rejected by limiter because of full queue.",
+		}, []string{"url", "feed"}),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "limiter", Name: "queue_depth", Help: "Queries queued by limiter now.",
+		}, []string{"url", "feed"}),
+		limit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "limiter", Name: "configured_qps", Help: "Configured qps of limiter.",
+		}, []string{"url", "feed"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "limiter", Name: "sent_total", Help: "Queries sent by limiter to sender.",
+		}, []string{"url", "feed"}),
+		achieved: newAchievedQPS(prometheus.NewDesc(prometheus.BuildFQName(namespace, "limiter", "achieved_qps"),
+			"Queries sent by limiter during last whole second.", []string{"url", "feed"}, nil)),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sender", Name: "retries_total", Help: "Failed requests queued again for retry.",
+		}, []string{"url", "feed"}),
+		shorts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sender", Name: "short_circuited_total", Help: "Requests short-circuited by open breaker.",
+		}, []string{"url", "feed"}),
+		dead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sender", Name: "dead_letters_total", Help: "Undeliverable requests stored as dead letters.",
+		}, []string{"url", "feed"}),
+		quotas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "limiter", Name: "quota_remaining", Help: "Remaining quota of url (empty feed) or of feed for url in current period.",
+		}, []string{"url", "feed"}),
+		breakers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "sender", Name: "breaker_state", Help: "State of url breaker: 0 - closed, 1 - half-open, 2 - open.",
+		}, []string{"url"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sender", Name: "requests_total", Help: "Outgoing requests by status code class.",
+		}, []string{"url", "feed", "code"}),
+		latencies: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "sender", Name: "request_duration_seconds", Help: "Latency of outgoing requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"url", "feed"}),
+	}
+	m.registry.MustRegister(m.fanouts, m.enqueued, m.dropped, m.depth, m.limit, m.sent, m.achieved, m.retries, m.shorts, m.dead, m.quotas, m.breakers, m.requests, m.latencies,
+		prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return m
+}
+
+// Handler returns http handler exposing metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) Fanout(feedID string) {
+	m.fanouts.WithLabelValues(feedID).Inc()
+}
+
+func (m *Metrics) Enqueued(urlID, feedID string) {
+	m.enqueued.WithLabelValues(urlID, feedID).Inc()
+}
+
+func (m *Metrics) Dropped(urlID, feedID string) {
+	m.dropped.WithLabelValues(urlID, feedID).Inc()
+}
+
+func (m *Metrics) QueueDepth(urlID, feedID string, depth int) {
+	m.depth.WithLabelValues(urlID, feedID).Set(float64(depth))
+}
+
+func (m *Metrics) Limit(urlID, feedID string, limit int) {
+	m.limit.WithLabelValues(urlID, feedID).Set(float64(limit))
+}
+
+func (m *Metrics) Sent(urlID, feedID string) {
+	m.sent.WithLabelValues(urlID, feedID).Inc()
+	m.achieved.inc(urlID, feedID, time.Now())
+}
+
+func (m *Metrics) Retry(urlID, feedID string) {
+	m.retries.WithLabelValues(urlID, feedID).Inc()
+}
+
+func (m *Metrics) ShortCircuit(urlID, feedID string) {
+	m.shorts.WithLabelValues(urlID, feedID).Inc()
+}
+
+func (m *Metrics) DeadLetter(urlID, feedID string) {
+	m.dead.WithLabelValues(urlID, feedID).Inc()
+}
+
+func (m *Metrics) QuotaRemaining(urlID, feedID string, remaining int64) {
+	m.quotas.WithLabelValues(urlID, feedID).Set(float64(remaining))
+}
+
+func (m *Metrics) BreakerState(urlID, state string) {
+	value := 0.0
+	switch state {
+	case sender.BreakerHalfOpen:
+		value = 1
+	case sender.BreakerOpen:
+		value = 2
+	}
+	m.breakers.WithLabelValues(urlID).Set(value)
+}
+
+func (m *Metrics) Request(urlID, feedID string, code int, latency time.Duration) {
+	m.requests.WithLabelValues(urlID, feedID, codeClass(code)).Inc()
+	m.latencies.WithLabelValues(urlID, feedID).Observe(latency.Seconds())
+}
+
+// codeClasses are values of code label of requests.
+var codeClasses = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "error"}
+
+func (m *Metrics) Remove(urlID, feedID string) {
+	if urlID == "" {
+		m.fanouts.DeleteLabelValues(feedID)
+		return
+	}
+	if feedID == "" {
+		m.breakers.DeleteLabelValues(urlID)
+		m.quotas.DeleteLabelValues(urlID, feedID)
+		return
+	}
+	for _, vec := range []interface{ DeleteLabelValues(...string) bool }{
+		m.enqueued, m.dropped, m.depth, m.limit, m.sent, m.retries, m.shorts, m.dead, m.quotas, m.latencies,
+	} {
+		vec.DeleteLabelValues(urlID, feedID)
+	}
+	for _, class := range codeClasses {
+		m.requests.DeleteLabelValues(urlID, feedID, class)
+	}
+	m.achieved.remove(urlID, feedID)
+}
+
+// codeClass returns class of status code: 2xx, 3xx, 4xx, 5xx or error for failed request.
+func codeClass(code int) string {
+	if code < 100 || code > 599 {
+		return "error"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// achievedQPS counts queries per second of each limiter and exposes count of last whole second.
+type achievedQPS struct {
+	desc    *prometheus.Desc
+	mu      sync.Mutex
+	seconds map[[2]string]*secondCount
+}
+
+type secondCount struct {
+	second    int64
+	cur, prev int
+}
+
+func newAchievedQPS(desc *prometheus.Desc) *achievedQPS {
+	return &achievedQPS{desc: desc, seconds: make(map[[2]string]*secondCount)}
+}
+
+func (a *achievedQPS) inc(urlID, feedID string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := [2]string{urlID, feedID}
+	c, ok := a.seconds[key]
+	if !ok {
+		c = &secondCount{}
+		a.seconds[key] = c
+	}
+	sec := now.Unix()
+	switch {
+	case sec == c.second:
+	case sec == c.second+1:
+		c.second, c.prev, c.cur = sec, c.cur, 0
+	default:
+		c.second, c.prev, c.cur = sec, 0, 0
+	}
+	c.cur++
+}
+
+func (a *achievedQPS) remove(urlID, feedID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.seconds, [2]string{urlID, feedID})
+}
+
+func (a *achievedQPS) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+func (a *achievedQPS) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sec := time.Now().Unix()
+	for key, c := range a.seconds {
+		qps := 0
+		switch sec {
+		case c.second:
+			qps = c.prev
+		case c.second + 1:
+			qps = c.cur
+		}
+		ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, float64(qps), key[0], key[1])
+	}
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+var _ entity.FanParamChecker = (*JSONRepo)(nil)
+
+// Check reports fields of json file unknown to fanout parameters, they are silently ignored by Load
+// (e.g. misspelled optional setting).
+func (r *JSONRepo) Check() ([]entity.Problem, error) {
+	dat, err := ioutil.ReadFile(r.jsonPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLoadJson)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(dat, &doc); err != nil {
+		return nil, errors.Wrapf(err, ErrLoadJson)
+	}
+	return unknownFields("$", doc, reflect.TypeOf(entity.FanParam{})), nil
+}
+
+// unknownFields returns problems of json object fields not matching fields of type t at any depth,
+// names are matched case-insensitively like by json.Unmarshal.
+func unknownFields(path string, doc interface{}, t reflect.Type) []entity.Problem {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var problems []entity.Problem
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil // type mismatch is reported by Load
+		}
+		fields := jsonFields(t)
+		for _, name := range sortedKeys(obj) {
+			value := obj[name]
+			field, ok := fields[strings.ToLower(name)]
+			if !ok {
+				problems = append(problems, entity.Problem{Path: path + "." + name, Message: "unknown field"})
+				continue
+			}
+			problems = append(problems, unknownFields(path+"."+name, value, field.Type)...)
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, value := range arr {
+			problems = append(problems, unknownFields(fmt.Sprintf("%v[%d]", path, i), value, t.Elem())...)
+		}
+	case reflect.Map:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(obj) {
+			problems = append(problems, unknownFields(path+"."+key, obj[key], t.Elem())...)
+		}
+	}
+	return problems
+}
+
+// jsonFields returns fields of struct by lowercase json names.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field
+	}
+	return fields
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	ErrPutLetter    = "can't store dead letter"
+	ErrListLetters  = "can't read dead letters"
+	ErrWriteLetters = "can't write dead letters"
+	ErrLockLetters  = "can't lock dead letters"
+)
+
+var _ entity.DeadLetterRepo = (*DLQRepo)(nil)
+
+// DLQRepo stores dead letters in file of json lines. File is opened for each operation under advisory lock
+// of file with .lock suffix, so it may be listed and purged by another process (fanouter dlq command) while service
+// is running.
+type DLQRepo struct {
+	path string
+	mu   sync.Mutex
+	next uint64 // counter of letters making their ids unique
+}
+
+func NewDLQRepo(path string) *DLQRepo {
+	return &DLQRepo{path: path}
+}
+
+func (r *DLQRepo) Put(letter entity.DeadLetter) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	letter.ID = strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(atomic.AddUint64(&r.next, 1), 36)
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, ErrPutLetter)
+	}
+	defer file.Close()
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrapf(err, ErrPutLetter)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.Wrapf(err, ErrPutLetter)
+	}
+	return nil
+}
+
+func (r *DLQRepo) List() ([]entity.DeadLetter, error) {
+	unlock, err := r.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	letters, err := r.list()
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrListLetters)
+	}
+	return letters, nil
+}
+
+func (r *DLQRepo) list() ([]entity.DeadLetter, error) {
+	file, err := os.Open(r.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var letters []entity.DeadLetter
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var letter entity.DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			continue // the last line may be written partly before crash
+		}
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
+
+// Delete rewrites file without letters with ids, the file is replaced atomically.
+func (r *DLQRepo) Delete(ids ...string) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	letters, err := r.list()
+	if err != nil {
+		return errors.Wrapf(err, ErrWriteLetters)
+	}
+	tmp := r.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrapf(err, ErrWriteLetters)
+	}
+	wr := bufio.NewWriter(file)
+	enc := json.NewEncoder(wr)
+	for _, letter := range letters {
+		if deleted[letter.ID] {
+			continue
+		}
+		if err := enc.Encode(letter); err != nil {
+			file.Close()
+			return errors.Wrapf(err, ErrWriteLetters)
+		}
+	}
+	if err := wr.Flush(); err != nil {
+		file.Close()
+		return errors.Wrapf(err, ErrWriteLetters)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, ErrWriteLetters)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return errors.Wrapf(err, ErrWriteLetters)
+	}
+	return nil
+}
+
+func (r *DLQRepo) Purge() error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, ErrWriteLetters)
+	}
+	return nil
+}
+
+// lock takes mutex of process and advisory lock of lock file shared with other processes, it returns function
+// releasing them. The file of letters is not locked itself as it is replaced by Delete.
+func (r *DLQRepo) lock() (func(), error) {
+	r.mu.Lock()
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		r.mu.Unlock()
+		return nil, errors.Wrapf(err, ErrLockLetters)
+	}
+	file, err := os.OpenFile(r.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, errors.Wrapf(err, ErrLockLetters)
+	}
+	if err := flock(file); err != nil {
+		file.Close()
+		r.mu.Unlock()
+		return nil, errors.Wrapf(err, ErrLockLetters)
+	}
+	return func() {
+		file.Close() // lock is released with file
+		r.mu.Unlock()
+	}, nil
+}
@@ -1,22 +1,36 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/errors"
 
 	"github.com/shipa988/fanouter/internal/domain/entity"
 )
 
 const (
-	ErrLoadJson = "can't load json config"
+	ErrLoadJson  = "can't load json config"
+	ErrWatchJson = "can't watch json config"
+	ErrWriteJson = "can't write json config"
 )
 
+// watchDelay is time of silence after last file event before notifying about change (editors write files in several steps).
+const watchDelay = 200 * time.Millisecond
+
 var _ entity.FanParamRepo = (*JSONRepo)(nil)
+var _ entity.FanParamWatcher = (*JSONRepo)(nil)
+var _ entity.FanParamWriter = (*JSONRepo)(nil)
 
 type JSONRepo struct {
 	jsonPath string
+	mu       sync.Mutex // serializes changes of file
 }
 
 func NewJSONRepo(path string) *JSONRepo {
@@ -35,3 +49,79 @@ func (r *JSONRepo) Load() (*entity.FanParam, error) {
 	}
 	return &urls, nil
 }
+
+// Watch watches directory of json file (so file replacing is noticed too) and notifies about changes of the file.
+func (r *JSONRepo) Watch(ctx context.Context) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrWatchJson)
+	}
+	if err := watcher.Add(filepath.Dir(r.jsonPath)); err != nil {
+		watcher.Close()
+		return nil, errors.Wrapf(err, ErrWatchJson)
+	}
+	name := filepath.Clean(r.jsonPath)
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer watcher.Close()
+		var (
+			timer *time.Timer
+			fire  <-chan time.Time
+		)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != name || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.NewTimer(watchDelay)
+				fire = timer.C
+			case <-fire:
+				fire = nil
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// Update applies change to stored parameters and rewrites json file atomically, so the file is never partially written.
+func (r *JSONRepo) Update(change func(params *entity.FanParam) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	params, err := r.Load()
+	if err != nil {
+		return err
+	}
+	if err := change(params); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, ErrWriteJson)
+	}
+	tmp := r.jsonPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrapf(err, ErrWriteJson)
+	}
+	if err := os.Rename(tmp, r.jsonPath); err != nil {
+		return errors.Wrapf(err, ErrWriteJson)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build !windows
+
+package repository
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes exclusive advisory lock of file, it waits until lock is released by other processes.
+func flock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
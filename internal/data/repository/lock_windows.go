@@ -0,0 +1,8 @@
+package repository
+
+import "os"
+
+// flock does nothing: advisory locks are not supported, access of file is serialized within process only.
+func flock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	ErrReadQuotas  = "can't read quota usage"
+	ErrWriteQuotas = "can't write quota usage"
+)
+
+var _ entity.QuotaRepo = (*QuotaRepo)(nil)
+
+// QuotaRepo stores quota usage in json file, the file is replaced atomically on each save.
+type QuotaRepo struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewQuotaRepo(path string) *QuotaRepo {
+	return &QuotaRepo{path: path}
+}
+
+func (r *QuotaRepo) Load() ([]entity.QuotaUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrReadQuotas)
+	}
+	var usage []entity.QuotaUsage
+	if err := json.Unmarshal(b, &usage); err != nil {
+		return nil, errors.Wrapf(err, ErrReadQuotas)
+	}
+	return usage, nil
+}
+
+func (r *QuotaRepo) Save(usage []entity.QuotaUsage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, ErrWriteQuotas)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return errors.Wrapf(err, ErrWriteQuotas)
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrapf(err, ErrWriteQuotas)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return errors.Wrapf(err, ErrWriteQuotas)
+	}
+	return nil
+}
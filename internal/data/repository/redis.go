@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+)
+
+const (
+	ErrRedis      = "redis request failed"
+	ErrRedisReply = "unexpected redis reply %q"
+)
+
+// redisTimeout is timeout of redis request if context has no deadline.
+const redisTimeout = time.Second
+
+// redisPoolSize is max count of idle connections to redis.
+const redisPoolSize = 16
+
+var _ limiter.Store = (*RedisStore)(nil)
+
+// RedisStore is store of shared logs in redis (or server speaking redis protocol).
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+func NewRedisStore(addr, password string, db int) *RedisStore {
+	return &RedisStore{addr: addr, password: password, db: db, pool: make(chan *redisConn, redisPoolSize)}
+}
+
+// Add trims log kept as sorted set by time in microseconds, adds entry, counts entries and reads the oldest one
+// by one round trip. Log expires after two its windows without additions.
+func (s *RedisStore) Add(ctx context.Context, key, entry string, at, since time.Time) (count int64, oldest time.Time, err error) {
+	ttl := 2 * at.Sub(since)
+	err = s.do(ctx, func(conn *redisConn) error {
+		conn.write("ZREMRANGEBYSCORE", key, "-inf", "("+micros(since))
+		conn.write("ZADD", key, micros(at), entry)
+		conn.write("ZCARD", key)
+		conn.write("ZRANGE", key, "0", "0", "WITHSCORES")
+		conn.write("PEXPIRE", key, strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+		if _, err := conn.int(); err != nil {
+			return err
+		}
+		if _, err := conn.int(); err != nil {
+			return err
+		}
+		var err error
+		if count, err = conn.int(); err != nil {
+			return err
+		}
+		values, err := conn.array()
+		if err != nil {
+			return err
+		}
+		oldest = at
+		if len(values) == 2 {
+			score, err := strconv.ParseFloat(values[1], 64)
+			if err != nil {
+				return err
+			}
+			oldest = time.Unix(0, int64(score)*int64(time.Microsecond))
+		}
+		_, err = conn.int()
+		return err
+	})
+	return count, oldest, err
+}
+
+func (s *RedisStore) Remove(ctx context.Context, key, entry string) error {
+	return s.do(ctx, func(conn *redisConn) error {
+		conn.write("ZREM", key, entry)
+		_, err := conn.int()
+		return err
+	})
+}
+
+// micros returns time as count of microseconds which is exact score of sorted set.
+func micros(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+}
+
+// do sends request by connection from pool, connection is closed after failed request.
+func (s *RedisStore) do(ctx context.Context, request func(conn *redisConn) error) error {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return errors.Wrapf(err, ErrRedis)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	conn.SetDeadline(deadline) //nolint:errcheck
+	if err := request(conn); err != nil {
+		conn.Close()
+		return errors.Wrapf(err, ErrRedis)
+	}
+	s.put(conn)
+	return nil
+}
+
+// conn returns idle connection or dials new one.
+func (s *RedisStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.pool:
+		return conn, nil
+	default:
+	}
+	d := net.Dialer{Timeout: redisTimeout}
+	c, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	conn.SetDeadline(time.Now().Add(redisTimeout)) //nolint:errcheck
+	if s.password != "" {
+		conn.write("AUTH", s.password)
+		if err := conn.ok(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		conn.write("SELECT", strconv.Itoa(s.db))
+		if err := conn.ok(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *RedisStore) put(conn *redisConn) {
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// redisConn is connection speaking redis protocol (RESP).
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// write buffers command, it is sent by reading of reply.
+func (c *redisConn) write(args ...string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// reply reads reply line, error reply is returned as error.
+func (c *redisConn) reply() (string, error) {
+	if err := c.w.Flush(); err != nil {
+		return "", err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", errors.Errorf(ErrRedisReply, line)
+	}
+	line = line[:len(line)-2]
+	if line[0] == '-' {
+		return "", errors.New(line[1:])
+	}
+	return line, nil
+}
+
+func (c *redisConn) ok() error {
+	line, err := c.reply()
+	if err != nil {
+		return err
+	}
+	if line[0] != '+' {
+		return errors.Errorf(ErrRedisReply, line)
+	}
+	return nil
+}
+
+// bulk reads value of bulk string reply started by line, ok is false for nil reply.
+func (c *redisConn) bulk(line string) (value string, ok bool, err error) {
+	if line[0] != '$' {
+		return "", false, errors.Errorf(ErrRedisReply, line)
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return "", false, err
+	}
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return "", false, err
+	}
+	return string(buf[:size]), true, nil
+}
+
+// array reads array reply of bulk strings.
+func (c *redisConn) array() ([]string, error) {
+	line, err := c.reply()
+	if err != nil {
+		return nil, err
+	}
+	if line[0] != '*' {
+		return nil, errors.Errorf(ErrRedisReply, line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if line, err = c.reply(); err != nil {
+			return nil, err
+		}
+		value, _, err := c.bulk(line)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (c *redisConn) int() (int64, error) {
+	line, err := c.reply()
+	if err != nil {
+		return 0, err
+	}
+	if line[0] != ':' {
+		return 0, errors.Errorf(ErrRedisReply, line)
+	}
+	return strconv.ParseInt(line[1:], 10, 64)
+}
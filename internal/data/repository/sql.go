@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	ErrLoadSQL    = "can't load fanout parameters from database"
+	ErrMigrateSQL = "can't migrate schema of database to version %v"
+	ErrOptionsSQL = "bad options of %v %v"
+	ErrSettingSQL = "bad setting %v"
+)
+
+// Names of process settings in settings table.
+const (
+	settingTimeout  = "timeout"
+	settingPoolSize = "poolsize"
+	settingLimit    = "limit"
+)
+
+// migrations are statements of schema versions, migration i creates version i+1. Settings of urls and feeds
+// not having own columns are kept in options column as json of entity.URL and entity.Feed, position columns
+// keep order of urls and of feeds of url. Only portable sql is used, so schema fits any database/sql driver.
+var migrations = [][]string{
+	{
+		`CREATE TABLE settings (
+			name  VARCHAR(64) PRIMARY KEY,
+			value VARCHAR(255) NOT NULL
+		)`,
+		`CREATE TABLE feed_limits (
+			feed_id   VARCHAR(255) PRIMARY KEY,
+			qps_limit VARCHAR(32) NOT NULL
+		)`,
+		`CREATE TABLE urls (
+			id        VARCHAR(255) PRIMARY KEY,
+			position  INTEGER NOT NULL DEFAULT 0,
+			value     TEXT NOT NULL,
+			qps_limit VARCHAR(32) NOT NULL DEFAULT '',
+			options   TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE feeds (
+			url_id    VARCHAR(255) NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+			feed_id   VARCHAR(255) NOT NULL,
+			position  INTEGER NOT NULL DEFAULT 0,
+			qps_limit VARCHAR(32) NOT NULL,
+			options   TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (url_id, feed_id)
+		)`,
+	},
+}
+
+var _ entity.FanParamRepo = (*SQLRepo)(nil)
+
+// SQLRepo loads fanout parameters from tables of sql database, so they can be managed by any database admin tool.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+// Migrate creates or upgrades schema of database to the latest version, each version is applied in transaction.
+func (r *SQLRepo) Migrate() error {
+	if _, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return errors.Wrapf(err, ErrMigrateSQL, 0)
+	}
+	var version int
+	if err := r.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return errors.Wrapf(err, ErrMigrateSQL, 0)
+	}
+	for ; version < len(migrations); version++ {
+		if err := r.migrate(version+1, migrations[version]); err != nil {
+			return errors.Wrapf(err, ErrMigrateSQL, version+1)
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepo) migrate(version int, statements []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (` + strconv.Itoa(version) + `)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Load reads parameters in one transaction, so they are consistent while admin tool changes them.
+func (r *SQLRepo) Load() (*entity.FanParam, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrLoadSQL)
+	}
+	defer tx.Rollback()
+	params := &entity.FanParam{URLs: []entity.URL{}}
+	if err := loadSettings(tx, params); err != nil {
+		return nil, errors.Wrapf(err, ErrLoadSQL)
+	}
+	if params.Feeds, err = loadFeedLimits(tx); err != nil {
+		return nil, errors.Wrapf(err, ErrLoadSQL)
+	}
+	if params.URLs, err = loadURLs(tx); err != nil {
+		return nil, errors.Wrapf(err, ErrLoadSQL)
+	}
+	return params, nil
+}
+
+func loadSettings(tx *sql.Tx, params *entity.FanParam) error {
+	rows, err := tx.Query(`SELECT name, value FROM settings`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		switch name {
+		case settingTimeout:
+			if params.TimeOut, err = strconv.Atoi(value); err != nil {
+				return errors.Wrapf(err, ErrSettingSQL, name)
+			}
+		case settingPoolSize:
+			if params.PoolSize, err = strconv.Atoi(value); err != nil {
+				return errors.Wrapf(err, ErrSettingSQL, name)
+			}
+		case settingLimit:
+			params.Limit = value
+		}
+	}
+	return rows.Err()
+}
+
+func loadFeedLimits(tx *sql.Tx) ([]entity.FeedLimit, error) {
+	rows, err := tx.Query(`SELECT feed_id, qps_limit FROM feed_limits ORDER BY feed_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var limits []entity.FeedLimit
+	for rows.Next() {
+		var limit entity.FeedLimit
+		if err := rows.Scan(&limit.ID, &limit.Limit); err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	return limits, rows.Err()
+}
+
+func loadURLs(tx *sql.Tx) ([]entity.URL, error) {
+	rows, err := tx.Query(`SELECT id, value, qps_limit, options FROM urls ORDER BY position, id`)
+	if err != nil {
+		return nil, err
+	}
+	urls := []entity.URL{}
+	index := make(map[string]int) // url id -> index in urls
+	for rows.Next() {
+		var (
+			url                     entity.URL
+			id, value, lim, options string
+		)
+		if err := rows.Scan(&id, &value, &lim, &options); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if options != "" {
+			if err := json.Unmarshal([]byte(options), &url); err != nil {
+				rows.Close()
+				return nil, errors.Wrapf(err, ErrOptionsSQL, "url", id)
+			}
+		}
+		url.ID, url.Value, url.Limit, url.Feeds = id, value, lim, nil
+		index[id] = len(urls)
+		urls = append(urls, url)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`SELECT url_id, feed_id, qps_limit, options FROM feeds ORDER BY url_id, position, feed_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			feed                        entity.Feed
+			urlID, feedID, lim, options string
+		)
+		if err := rows.Scan(&urlID, &feedID, &lim, &options); err != nil {
+			return nil, err
+		}
+		i, ok := index[urlID]
+		if !ok {
+			continue
+		}
+		if options != "" {
+			if err := json.Unmarshal([]byte(options), &feed); err != nil {
+				return nil, errors.Wrapf(err, ErrOptionsSQL, "feed", urlID+"/"+feedID)
+			}
+		}
+		feed.ID, feed.Limit = feedID, lim
+		urls[i].Feeds = append(urls[i].Feeds, feed)
+	}
+	return urls, rows.Err()
+}
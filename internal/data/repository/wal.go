@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+)
+
+const (
+	ErrOpenWAL  = "can't open write-ahead log %v"
+	ErrWriteWAL = "can't write to write-ahead log %v"
+	ErrReadWAL  = "can't read message %v from write-ahead log %v"
+)
+
+// compactMin is count of records in log after which log is rewritten with not acked messages only,
+// if acked ones are the most of it.
+const compactMin = 10000
+
+var _ limiter.JournalFabric = (*WALFabric)(nil)
+var _ limiter.Journal = (*WAL)(nil)
+
+// WALFabric opens write-ahead logs of durable queues in directory, one file per feed of url.
+// Limiters of the same feed and url (old one draining after reload and new one) share one log.
+type WALFabric struct {
+	dir  string
+	mu   sync.Mutex
+	wals map[string]*WAL
+}
+
+func NewWALFabric(dir string) *WALFabric {
+	return &WALFabric{dir: dir, wals: make(map[string]*WAL)}
+}
+
+func (f *WALFabric) OpenJournal(urlID, feedID string) (limiter.Journal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := walName(urlID) + "_" + walName(feedID) + ".wal"
+	if w, ok := f.wals[name]; ok {
+		w.refs++
+		return w, nil
+	}
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, ErrOpenWAL, f.dir)
+	}
+	w := &WAL{fabric: f, name: name, path: filepath.Join(f.dir, name), refs: 1, pending: make(map[uint64]walSpan)}
+	if err := w.open(); err != nil {
+		return nil, errors.Wrapf(err, ErrOpenWAL, w.path)
+	}
+	f.wals[name] = w
+	return w, nil
+}
+
+// walName escapes id to be a part of file name.
+func walName(id string) string {
+	return strings.NewReplacer(".", "%2E", "_", "%5F").Replace(url.PathEscape(id))
+}
+
+// walRecord is line of log: stored message or ack of message with sequence number.
+type walRecord struct {
+	Seq uint64          `json:"seq,omitempty"`
+	Msg *entity.Message `json:"msg,omitempty"`
+	Ack uint64          `json:"ack,omitempty"`
+}
+
+// walSpan is place of line of stored message in log.
+type walSpan struct {
+	off int64
+	n   int
+}
+
+// WAL is write-ahead log of durable queue: file of json lines with stored messages and acks. Only places of not
+// acked messages are kept in memory, messages are read from file when limiter queues them.
+type WAL struct {
+	fabric *WALFabric
+	name   string
+	path   string
+	refs   int // guarded by mutex of fabric
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64 // offset of the next record
+	seq     uint64
+	records int
+	pending map[uint64]walSpan
+	replay  []uint64
+}
+
+// open reads places of not acked messages from log and rewrites it with them only.
+func (w *WAL) open() error {
+	file, err := os.Open(w.path)
+	switch {
+	case os.IsNotExist(err):
+		return w.compact(nil)
+	case err != nil:
+		return err
+	}
+	defer file.Close()
+	rd := bufio.NewReader(file)
+	var off int64
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF { // the last line may be written partly before crash
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err == nil {
+			if rec.Msg != nil {
+				w.pending[rec.Seq] = walSpan{off: off, n: len(line)}
+			} else {
+				delete(w.pending, rec.Ack)
+			}
+			if rec.Seq > w.seq {
+				w.seq = rec.Seq
+			}
+		}
+		off += int64(len(line))
+	}
+	for seq := range w.pending {
+		w.replay = append(w.replay, seq)
+	}
+	sort.Slice(w.replay, func(i, j int) bool { return w.replay[i] < w.replay[j] })
+	return w.compact(file)
+}
+
+// compact rewrites log with not acked messages read from src only, the file is replaced atomically.
+func (w *WAL) compact(src *os.File) error {
+	tmp := w.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	seqs := make([]uint64, 0, len(w.pending))
+	for seq := range w.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	wr := bufio.NewWriter(file)
+	pending := make(map[uint64]walSpan, len(seqs))
+	var off int64
+	for _, seq := range seqs {
+		span := w.pending[seq]
+		line := make([]byte, span.n)
+		if _, err := src.ReadAt(line, span.off); err != nil {
+			file.Close()
+			return err
+		}
+		if _, err := wr.Write(line); err != nil {
+			file.Close()
+			return err
+		}
+		pending[seq] = walSpan{off: off, n: span.n}
+		off += int64(span.n)
+	}
+	if err := wr.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return err
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file, err = os.OpenFile(w.path, os.O_APPEND|os.O_RDWR, 0644)
+	w.pending, w.size, w.records = pending, off, len(seqs)
+	return err
+}
+
+// write appends record to log.
+func (w *WAL) write(rec walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	n, err := w.file.Write(append(line, '\n'))
+	w.size += int64(n)
+	w.records++
+	return err
+}
+
+func (w *WAL) Append(msg entity.Message) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seq++
+	msg.Seq = w.seq
+	off := w.size
+	if err := w.write(walRecord{Seq: msg.Seq, Msg: &msg}); err != nil {
+		return 0, errors.Wrapf(err, ErrWriteWAL, w.path)
+	}
+	w.pending[msg.Seq] = walSpan{off: off, n: int(w.size - off)}
+	return msg.Seq, nil
+}
+
+func (w *WAL) Read(seq uint64) (entity.Message, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	span, ok := w.pending[seq]
+	if !ok {
+		return entity.Message{}, errors.Errorf(ErrReadWAL, seq, w.path)
+	}
+	line := make([]byte, span.n)
+	if _, err := w.file.ReadAt(line, span.off); err != nil {
+		return entity.Message{}, errors.Wrapf(err, ErrReadWAL, seq, w.path)
+	}
+	var rec walRecord
+	if err := json.Unmarshal(line, &rec); err != nil || rec.Msg == nil {
+		return entity.Message{}, errors.Errorf(ErrReadWAL, seq, w.path)
+	}
+	return *rec.Msg, nil
+}
+
+func (w *WAL) Ack(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.write(walRecord{Ack: seq}); err != nil {
+		return errors.Wrapf(err, ErrWriteWAL, w.path)
+	}
+	delete(w.pending, seq)
+	if w.records >= compactMin && w.records > 2*len(w.pending) {
+		if err := w.compact(w.file); err != nil {
+			return errors.Wrapf(err, ErrWriteWAL, w.path)
+		}
+	}
+	return nil
+}
+
+func (w *WAL) Replay() []uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	replay := w.replay
+	w.replay = nil
+	return replay
+}
+
+// Close closes log when the last limiter using it closes it.
+func (w *WAL) Close() error {
+	w.fabric.mu.Lock()
+	defer w.fabric.mu.Unlock()
+	if w.refs--; w.refs > 0 {
+		return nil
+	}
+	delete(w.fabric.wals, w.name)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
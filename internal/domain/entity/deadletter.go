@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// DeadLetter is query which could not be delivered to external url.
+type DeadLetter struct {
+	ID     string `json:"id"`
+	URLID  string `json:"urlid"`
+	FeedID string `json:"feedid"`
+	// outgoing request, it is empty if request could not be built
+	Method   string              `json:"method,omitempty"`
+	URL      string              `json:"url,omitempty"`
+	Header   map[string][]string `json:"header,omitempty"`
+	Body     []byte              `json:"body,omitempty"`
+	Attempts int                 `json:"attempts"`
+	Error    string              `json:"error"`
+	Time     time.Time           `json:"time"`
+	Message  Message             `json:"message"` // incoming query, it is queued again to limiter by replay
+}
+
+// DeadLetterRepo is store of dead letters.
+type DeadLetterRepo interface {
+	// Put stores letter, id is assigned by store.
+	Put(letter DeadLetter) error
+	List() ([]DeadLetter, error)
+	Delete(ids ...string) error
+	Purge() error
+}
@@ -1,6 +1,57 @@
 package entity
 
 type Feed struct {
-	ID    string `json:"id"`
-	Limit string `json:"limit"`
+	ID        string `json:"id"`
+	Limit     string `json:"limit"`
+	Algorithm string `json:"algorithm,omitempty"` // limiting algorithm, evenly-spaced ticker if empty
+	Burst     int    `json:"burst,omitempty"`     // max burst for algorithms allowing bursts
+	// Overflow is policy applied when queue of limiter is full: drop_newest (default), drop_oldest, block or reject.
+	Overflow     string `json:"overflow,omitempty"`
+	BlockTimeout int    `json:"blocktimeout,omitempty"` // max waiting for free place in queue in milliseconds for block policy
+	// Priority is class of queries of the feed without own priority: high, normal (default) or low.
+	// Starvation is max count of queries of higher classes sent while query of lower class waits (10).
+	Priority   string `json:"priority,omitempty"`
+	Starvation int    `json:"starvation,omitempty"`
+	// Share is weight of the feed in capacity of sender of the url shared with other feeds for the url (1 if 0).
+	Share int `json:"share,omitempty"`
+	// Durable queue of feed is stored on disk: queued queries are replayed after restart, overflow policy is not applied.
+	Durable bool `json:"durable,omitempty"`
+	// Mode sync makes fanout wait up to Deadline milliseconds for results of all urls of feed, Success is criteria
+	// of successful fanout: all (default), any or quorum of urls. Settings of the feed in the first url are used.
+	Mode     string `json:"mode,omitempty"`
+	Success  string `json:"success,omitempty"`
+	Deadline int    `json:"deadline,omitempty"`
+	// Routing of queries of the feed to the url: Match is condition on query, Sample is percentage of matched queries
+	// sent to the url (all if 0), one of urls of the feed with the same Group is picked by Weight (1 if 0).
+	Match  *Match  `json:"match,omitempty"`
+	Sample float64 `json:"sample,omitempty"`
+	Group  string  `json:"group,omitempty"`
+	Weight int     `json:"weight,omitempty"`
+	// Schedule is limits of the feed for the url by time of day, Limit is used out of its windows.
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// Quota is budget of requests of the feed to the url per period.
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Schedule is list of limit profiles of feed, the first window containing current time is active profile.
+type Schedule struct {
+	Timezone string   `json:"timezone,omitempty"` // IANA time zone of windows, UTC if empty
+	Windows  []Window `json:"windows"`
+}
+
+// Window is limit profile of feed active from From to To time of day ("HH:MM"), window with To not after From
+// passes midnight. Window is active on listed Days ("mon".."sun", day of start for window passing midnight)
+// or every day if Days are empty.
+type Window struct {
+	Name  string   `json:"name,omitempty"` // name of profile, From-To if empty
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Days  []string `json:"days,omitempty"`
+	Limit string   `json:"limit"`
+}
+
+// Match is condition on incoming query: all listed query params and headers must have the values.
+type Match struct {
+	Query   map[string]string `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
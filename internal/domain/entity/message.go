@@ -0,0 +1,26 @@
+package entity
+
+// Priority classes of queries, queued queries of higher class are sent first.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Message is incoming query of feed transmitted to external urls.
+type Message struct {
+	RequestID string
+	FeedID    string
+	Method    string
+	Header    map[string][]string
+	Query     map[string][]string
+	Body      []byte
+	Attempt   int    // count of failed attempts of sending to url
+	Seq       uint64 // sequence number of message in durable queue
+	Priority  string // priority class of query: high, normal (if empty) or low
+	// Reply receives result of sending to url for synchronous fanout, it is not stored with message.
+	Reply func(Result) `json:"-"`
+	// URLIDs restricts fanout to urls of feed with the ids without routing (all routed urls if empty),
+	// it is not stored with message.
+	URLIDs []string `json:"-"`
+}
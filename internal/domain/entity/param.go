@@ -1,11 +1,68 @@
 package entity
 
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
 type FanParam struct {
-	TimeOut  int   `json:"timeout"`
-	PoolSize int   `json:"poolsize"`
-	URLs     []URL `json:"urls"`
+	TimeOut  int         `json:"timeout"`
+	PoolSize int         `json:"poolsize"`
+	Limit    string      `json:"limit,omitempty"` // qps of all outgoing queries of the process
+	Feeds    []FeedLimit `json:"feeds,omitempty"` // qps of feeds across all their urls
+	URLs     []URL       `json:"urls"`
+}
+
+// FeedLimit is qps limit of feed across all its urls.
+type FeedLimit struct {
+	ID    string `json:"id"`
+	Limit string `json:"limit"`
 }
 
 type FanParamRepo interface {
 	Load() (*FanParam, error)
 }
+
+// FanParamWatcher is FanParamRepo which can notify about changes of stored parameters.
+type FanParamWatcher interface {
+	// Watch returns channel receiving a value after each change of parameters, channel is closed when ctx is done.
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// FanParamWriter is FanParamRepo which can change stored parameters.
+type FanParamWriter interface {
+	FanParamRepo
+	// Update applies change to stored parameters atomically, nothing is stored if change returns error.
+	Update(change func(params *FanParam) error) error
+}
+
+// Problem is invalid value of fanout parameters at json path, e.g. $.urls[0].feeds[1].limit.
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ParamError is returned for fanout parameters having problems, it lists all of them.
+type ParamError struct {
+	Problems []Problem
+}
+
+func (e *ParamError) Error() string {
+	b := strings.Builder{}
+	b.WriteString("invalid fanout parameters:")
+	for _, p := range e.Problems {
+		b.WriteString("\n  " + p.Path + ": " + p.Message)
+	}
+	return b.String()
+}
+
+// FanParamChecker is FanParamRepo which can check format of stored parameters, e.g. for unknown fields.
+type FanParamChecker interface {
+	Check() ([]Problem, error)
+}
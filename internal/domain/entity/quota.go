@@ -0,0 +1,38 @@
+package entity
+
+import "time"
+
+const (
+	QuotaDay   = "day"
+	QuotaMonth = "month"
+)
+
+const (
+	QuotaReject = "reject"
+	QuotaDefer  = "defer"
+)
+
+// Quota is budget of requests per day or month, it is restored at start of the next period.
+type Quota struct {
+	Limit    int64  `json:"limit"`
+	Period   string `json:"period"`             // day or month
+	Timezone string `json:"timezone,omitempty"` // IANA time zone of start of period, UTC if empty
+	// Exhausted is policy of incoming queries when quota is exhausted: reject (default) answers them 429 with
+	// Retry-After up to start of the next period, defer queues them to be sent in the next period.
+	Exhausted string `json:"exhausted,omitempty"`
+}
+
+// QuotaUsage is count of requests spent from quota of url (FeedID is empty) or of feed for url
+// in period started at Start.
+type QuotaUsage struct {
+	URLID  string    `json:"urlid"`
+	FeedID string    `json:"feedid,omitempty"`
+	Start  time.Time `json:"start"`
+	Used   int64     `json:"used"`
+}
+
+// QuotaRepo is store of quota usage surviving restarts.
+type QuotaRepo interface {
+	Load() ([]QuotaUsage, error)
+	Save(usage []QuotaUsage) error
+}
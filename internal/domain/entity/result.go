@@ -0,0 +1,31 @@
+package entity
+
+const (
+	ModeAsync = "async"
+	ModeSync  = "sync"
+)
+
+const (
+	SuccessAll    = "all"
+	SuccessAny    = "any"
+	SuccessQuorum = "quorum"
+)
+
+// Result is result of sending query to external url.
+type Result struct {
+	URLID   string  `json:"urlid"`
+	Code    int     `json:"code,omitempty"`
+	Latency float64 `json:"latency"` // milliseconds
+	Error   string  `json:"error,omitempty"`
+}
+
+// OK reports whether query was delivered: url answered with 2xx status.
+func (r Result) OK() bool {
+	return r.Error == "" && r.Code >= 200 && r.Code < 300
+}
+
+// FanoutResult is aggregated result of synchronous fanout of query to urls of feed.
+type FanoutResult struct {
+	Success bool     `json:"success"`
+	Results []Result `json:"results"`
+}
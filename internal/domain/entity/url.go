@@ -1,7 +1,53 @@
 package entity
 
 type URL struct {
-	ID    string `json:"id"`
-	Value string `json:"value"`
-	Feeds []Feed `json:"feeds"`
+	ID       string            `json:"id"`
+	Value    string            `json:"value"`             // url or text/template of url
+	Limit    string            `json:"limit,omitempty"`   // qps of the url across all its feeds
+	Method   string            `json:"method,omitempty"`  // method of outgoing request, method of incoming query if empty
+	Headers  map[string]string `json:"headers,omitempty"` // static headers of outgoing request
+	Body     string            `json:"body,omitempty"`    // text/template of outgoing body, forwarded body is replaced by it
+	Feeds    []Feed            `json:"feeds"`
+	Params   *ParamMap         `json:"params,omitempty"`   // all query params and body are forwarded if nil
+	Retry    *Retry            `json:"retry,omitempty"`    // failed requests are not retried if nil
+	Breaker  *Breaker          `json:"breaker,omitempty"`  // requests are never short-circuited if nil
+	Adaptive *Adaptive         `json:"adaptive,omitempty"` // limits are not adapted to url if nil
+	Quota    *Quota            `json:"quota,omitempty"`    // quota of url across all its feeds
+}
+
+// Adaptive is AIMD settings adapting rate of url to its throttling (429, 503, Retry-After) and latency: limits of url
+// and its feeds are multiplied by rate decreased multiplicatively on overload and increased additively without it.
+// Defaults are used for empty fields.
+type Adaptive struct {
+	Decrease float64 `json:"decrease,omitempty"` // multiplier of rate on overload (0.5)
+	Increase float64 `json:"increase,omitempty"` // added to rate after interval without overload (0.1)
+	Min      float64 `json:"min,omitempty"`      // min rate (0.1)
+	Latency  int     `json:"latency,omitempty"`  // p99 latency of interval in milliseconds treated as overload, not checked if empty
+	Interval int     `json:"interval,omitempty"` // interval of adaptation in milliseconds (1000)
+}
+
+// Breaker is circuit breaker settings of url, defaults are used for empty fields.
+type Breaker struct {
+	FailureRate   float64 `json:"failurerate,omitempty"`   // part of failed requests in window opening breaker (0.5)
+	MinRequests   int     `json:"minrequests,omitempty"`   // min requests in window for calculating failure rate (10)
+	Window        int     `json:"window,omitempty"`        // window of counting requests in milliseconds (10000)
+	OpenTimeout   int     `json:"opentimeout,omitempty"`   // time of open state before probing in milliseconds (5000)
+	ProbeInterval int     `json:"probeinterval,omitempty"` // min interval between probe requests in half-open state in milliseconds (1000)
+	Probes        int     `json:"probes,omitempty"`        // successful probes closing breaker (3)
+}
+
+// Retry is policy of retrying failed requests to url with exponential backoff.
+type Retry struct {
+	Attempts int     `json:"attempts"`         // max attempts including the first one
+	Base     int     `json:"base"`             // backoff of the first retry in milliseconds, doubled for each next one
+	Cap      int     `json:"cap,omitempty"`    // max backoff in milliseconds, 1 minute if empty
+	Jitter   float64 `json:"jitter,omitempty"` // randomized part of backoff from 0 to 1
+	Codes    []int   `json:"codes,omitempty"`  // retryable status codes, 429 and 5xx gateway errors if empty
+}
+
+// ParamMap declares how incoming query is mapped onto outgoing request to url.
+type ParamMap struct {
+	Query   map[string]string `json:"query,omitempty"`   // incoming query param -> outgoing query param, other params are not forwarded
+	Headers []string          `json:"headers,omitempty"` // incoming headers copied to outgoing request
+	Body    bool              `json:"body,omitempty"`    // forward body of incoming query
 }
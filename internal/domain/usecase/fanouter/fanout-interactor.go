@@ -3,63 +3,711 @@ package fanouter
 import (
 	"context"
 	"errors"
+	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
+	pkgerrors "github.com/pkg/errors"
+
 	"github.com/shipa988/fanouter/internal/domain/entity"
 	"github.com/shipa988/fanouter/internal/domain/usecase"
 	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
 	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
 )
 
+const (
+	ErrStartURL  = "can't start sender for url %v"
+	ErrStartFeed = "can't start limiter of feed %v for url %v"
+	ErrDeadline  = "no result before deadline"
+)
+
+// defaultDeadline is max waiting for results of urls in synchronous fanout if feed has no deadline.
+const defaultDeadline = 10 * time.Second
+
+var (
+	ErrNotFound       = entity.ErrNotFound
+	ErrBadLimit       = errors.New("limit must be positive")
+	ErrNotInitialized = errors.New("fanouter is not initialized")
+	ErrNoDeadLetters  = errors.New("dead letters store is not set")
+)
+
+// PartialError is returned by fanout of query which is queued for some urls but rejected by others
+// because of full queue or exhausted quota. Err is *limiter.OverflowError or *limiter.QuotaError.
+type PartialError struct {
+	Err      error
+	Accepted []string // ids of urls the query is queued for
+	Rejected []string // ids of urls rejecting the query
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns rejection error of urls.
+func (e *PartialError) Cause() error {
+	return e.Err
+}
+
 var _ Fanouter = (*FanoutInteractor)(nil)
 
+// urlRoute is running sender of external url with limiters of its feeds.
+type urlRoute struct {
+	url      entity.URL
+	ctx      context.Context
+	cancel   context.CancelFunc
+	out      chan entity.Message
+	fair     *limiter.FairQueue // shares out among feeds by their weights
+	feeds    map[string]*feedRoute
+	limiters sync.WaitGroup // running limiters writing to fair queue (including draining ones)
+	done     chan struct{}  // closed when sender stopped
+	breaker  *sender.Breaker
+	adaptive *sender.Adaptive // rate of url applied to limits of url and its feeds
+	gate     *limiter.Gate    // limit of url across its feeds
+	quota    *limiter.Quota   // quota of url across its feeds
+}
+
+// feedRoute is running limiter of feed for external url.
+type feedRoute struct {
+	feed     entity.Feed
+	limiter  limiter.QPSLimiter
+	flow     *limiter.Flow // input of fair queue of url written by limiter
+	schedule *schedule     // limit profiles of feed, nil if feed has no schedule
+	profile  string        // active profile
+	override string        // limit set by admin api if store is read-only, used until stored limit is changed
+	quota    *limiter.Quota
+	stopped  chan struct{} // closed when limiter has sent queued items and stopped
+}
+
+// fanoutFeed is limiters of feed for all its urls with fanout settings of feed.
+type fanoutFeed struct {
+	sync     bool
+	success  string
+	deadline time.Duration
+	targets  []fanoutTarget
+}
+
+type fanoutTarget struct {
+	urlID   string
+	feed    entity.Feed // routing settings of feed for url
+	limiter limiter.QPSLimiter
+	quotas  []*limiter.Quota // quotas of url and of feed for url
+}
+
 type FanoutInteractor struct {
 	sendersFabric    sender.QuerySenderFabric
 	paramsRepo       entity.FanParamRepo
 	qpsLimiterFabric limiter.QPSLimiterFabric
-	feeds            map[string][]chan<- string
+	mu               sync.RWMutex
+	ctx              context.Context
+	params           *entity.FanParam
+	routes           map[string]*urlRoute   // url id -> route
+	feeds            map[string]*fanoutFeed // feed id -> limiters of feed urls
 	logger           usecase.Logger
+	metrics          usecase.Metrics
+	deadLetters      entity.DeadLetterRepo
+	global           *limiter.Gate            // limit of the process
+	feedGates        map[string]*limiter.Gate // feed id -> limit of feed across its urls
+	quotas           map[quotaKey]*limiter.Quota
+	quotaRepo        entity.QuotaRepo
+	saved            map[quotaKey]entity.QuotaUsage // quota usage saved before restart and not restored yet
+	jobs             sync.WaitGroup                 // background jobs started by Init
+	now              func() time.Time               // clock of schedules of feeds
 }
 
-func NewFanoutInteractor(paramsRepo entity.FanParamRepo, sendersFabric sender.QuerySenderFabric, qpsLimiterFabric limiter.QPSLimiterFabric, logger usecase.Logger) *FanoutInteractor {
-	return &FanoutInteractor{paramsRepo: paramsRepo, sendersFabric: sendersFabric, qpsLimiterFabric: qpsLimiterFabric, logger: logger}
+func NewFanoutInteractor(paramsRepo entity.FanParamRepo, sendersFabric sender.QuerySenderFabric, qpsLimiterFabric limiter.QPSLimiterFabric, logger usecase.Logger, metrics usecase.Metrics) *FanoutInteractor {
+	return &FanoutInteractor{paramsRepo: paramsRepo, sendersFabric: sendersFabric, qpsLimiterFabric: qpsLimiterFabric, logger: logger, metrics: metrics,
+		global: limiter.NewGate(0), feedGates: make(map[string]*limiter.Gate), quotas: make(map[quotaKey]*limiter.Quota), now: time.Now}
+}
+
+// WithClock sets clock of schedules of feeds instead of system one, it must be set before Init.
+func (f *FanoutInteractor) WithClock(now func() time.Time) *FanoutInteractor {
+	f.now = now
+	return f
+}
+
+// WithDeadLetters sets store of queries which senders can't deliver, it must be set before Init.
+func (f *FanoutInteractor) WithDeadLetters(deadLetters entity.DeadLetterRepo) *FanoutInteractor {
+	f.deadLetters = deadLetters
+	return f
 }
 
 func (f *FanoutInteractor) Init(ctx context.Context) (err error) {
-	params, err := f.paramsRepo.Load()
+	params, err := Load(f.paramsRepo, f.qpsLimiterFabric)
 	if err != nil {
 		return
 	}
-	f.feeds = make(map[string][]chan<- string)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.loadQuotas(); err != nil {
+		return
+	}
+	f.ctx = ctx
+	f.params = nil
+	f.routes = make(map[string]*urlRoute)
+	f.apply(params)
+	f.jobs.Add(2)
+	go f.runSchedules(ctx)
+	go f.runQuotas(ctx)
+	return nil
+}
+
+// Wait waits until background jobs started by Init stop after its ctx is done, quota usage is saved then.
+func (f *FanoutInteractor) Wait() {
+	f.jobs.Wait()
+}
 
+func (f *FanoutInteractor) Reload(ctx context.Context) error {
+	params, err := Load(f.paramsRepo, f.qpsLimiterFabric)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ctx == nil {
+		return ErrNotInitialized
+	}
+	f.apply(params)
+	f.logger.Log(ctx, "fanout parameters reloaded")
+	return nil
+}
+
+// apply reconciles running routes with params: starts added urls and feeds, drains and stops removed ones
+// and retunes changed limits. Unchanged routes keep working. Must be called under lock.
+func (f *FanoutInteractor) apply(params *entity.FanParam) {
+	f.applyGates(params)
+	senderChanged := f.params != nil && (f.params.TimeOut != params.TimeOut || f.params.PoolSize != params.PoolSize)
+	urls := make(map[string]entity.URL)
 	for _, url := range params.URLs {
-		sender := f.sendersFabric.NewQuerySender()
-		sender.Init(time.Second*time.Duration(params.TimeOut), params.PoolSize, f.logger)
-		c := make(chan string)
-		go sender.Send(ctx, url.Value, c)
+		urls[url.ID] = url
+	}
+	for id, route := range f.routes {
+		url, ok := urls[id]
+		if !ok || senderChanged || urlChanged(route.url, url) {
+			f.stopURL(route, !ok)
+			delete(f.routes, id)
+		}
+	}
+	for _, url := range params.URLs {
+		route, ok := f.routes[url.ID]
+		if !ok {
+			var err error
+			if route, err = f.startURL(params, url); err != nil {
+				f.logger.Log(f.ctx, err)
+				continue
+			}
+			f.routes[url.ID] = route
+		}
+		f.applyQuota(url.ID, "", url.Quota)
+		f.applyFeeds(route, url.Feeds)
+		lim, _ := strconv.Atoi(url.Limit)
+		route.gate.SetLimit(route.adaptive.Limit(lim))
+		route.url = url
+	}
+	f.pruneQuotas(params)
+	f.params = params
 
+	feeds := f.feeds
+	f.feeds = make(map[string]*fanoutFeed)
+	for _, url := range params.URLs { // in order of params: fanout settings of feed in the first url are used
+		route, ok := f.routes[url.ID]
+		if !ok {
+			continue
+		}
 		for _, feed := range url.Feeds {
-			if _, ok := f.feeds[feed.ID]; !ok {
-				f.feeds[feed.ID] = make([]chan<- string, 0)
+			fr, ok := route.feeds[feed.ID]
+			if !ok {
+				continue
 			}
-			lim, _ := strconv.Atoi(feed.Limit)
-			qpsLimiter := f.qpsLimiterFabric.NewQPSLimiter()
-			in := qpsLimiter.Init(c)
-			f.feeds[feed.ID] = append(f.feeds[feed.ID], in)
-			go qpsLimiter.DoLimiting(ctx, lim)
+			ff, ok := f.feeds[feed.ID]
+			if !ok {
+				ff = &fanoutFeed{sync: feed.Mode == entity.ModeSync, success: feed.Success, deadline: defaultDeadline}
+				if feed.Deadline > 0 {
+					ff.deadline = time.Duration(feed.Deadline) * time.Millisecond
+				}
+				f.feeds[feed.ID] = ff
+			}
+			ff.targets = append(ff.targets, fanoutTarget{urlID: url.ID, feed: feed, limiter: fr.limiter,
+				quotas: []*limiter.Quota{route.quota, fr.quota}})
 		}
 	}
-	return nil
+	for id := range feeds {
+		if _, ok := f.feeds[id]; !ok {
+			f.metrics.Remove("", id)
+		}
+	}
+}
+
+// applyGates sets global limit and limits of feeds across urls, limit of feed removed from params is removed.
+func (f *FanoutInteractor) applyGates(params *entity.FanParam) {
+	lim, _ := strconv.Atoi(params.Limit)
+	f.global.SetLimit(lim)
+	limits := make(map[string]int)
+	for _, feed := range params.Feeds {
+		limits[feed.ID], _ = strconv.Atoi(feed.Limit)
+	}
+	for id, gate := range f.feedGates {
+		gate.SetLimit(limits[id])
+	}
+	for id, lim := range limits {
+		f.feedGate(id).SetLimit(lim)
+	}
+}
+
+// feedGate returns gate of feed shared by limiters of the feed for all urls.
+func (f *FanoutInteractor) feedGate(feedID string) *limiter.Gate {
+	gate, ok := f.feedGates[feedID]
+	if !ok {
+		gate = limiter.NewGate(0)
+		f.feedGates[feedID] = gate
+	}
+	return gate
+}
+
+func (f *FanoutInteractor) applyFeeds(route *urlRoute, feeds []entity.Feed) {
+	newFeeds := make(map[string]entity.Feed)
+	for _, feed := range feeds {
+		newFeeds[feed.ID] = feed
+	}
+	for id, fr := range route.feeds {
+		if _, ok := newFeeds[id]; !ok {
+			fr.limiter.Close() // limiter sends queued items and stops
+			delete(route.feeds, id)
+			go func(urlID, feedID string, stopped <-chan struct{}) {
+				<-stopped
+				f.metrics.Remove(urlID, feedID)
+			}(route.url.ID, id, fr.stopped)
+		}
+	}
+	for _, feed := range feeds {
+		f.applyQuota(route.url.ID, feed.ID, feed.Quota)
+		fr, ok := route.feeds[feed.ID]
+		if ok && limiterChanged(fr.feed, feed) {
+			fr.limiter.Close() // another limiter is needed: old one sends queued items and stops
+			delete(route.feeds, feed.ID)
+			ok = false
+		}
+		if !ok {
+			if fr, err := f.startFeed(route, feed); err != nil {
+				f.logger.Log(f.ctx, err)
+			} else {
+				route.feeds[feed.ID] = fr
+			}
+			continue
+		}
+		scheduleChanged := !reflect.DeepEqual(fr.feed.Schedule, feed.Schedule)
+		if scheduleChanged {
+			fr.schedule = nil
+			if feed.Schedule != nil {
+				var err error
+				if fr.schedule, err = newSchedule(feed.Schedule); err != nil {
+					f.logger.Log(f.ctx, pkgerrors.Wrapf(err, ErrStartFeed, feed.ID, route.url.ID))
+				}
+			}
+		}
+		if fr.feed.Share != feed.Share {
+			route.fair.SetWeight(fr.flow, feed.Share)
+		}
+		limitChanged := fr.feed.Limit != feed.Limit
+		fr.feed = feed
+		if limitChanged {
+			fr.override = ""
+		}
+		if limitChanged || scheduleChanged {
+			var lim int
+			lim, fr.profile = fr.limit(f.now())
+			fr.limiter.SetLimit(route.adaptive.Limit(lim))
+			f.metrics.Limit(route.url.ID, feed.ID, lim)
+		}
+	}
+}
+
+func (f *FanoutInteractor) startURL(params *entity.FanParam, url entity.URL) (*urlRoute, error) {
+	if _, err := sender.NewRequestBuilder(url); err != nil {
+		return nil, pkgerrors.Wrapf(err, ErrStartURL, url.ID)
+	}
+	ctx, cancel := context.WithCancel(f.ctx)
+	route := &urlRoute{
+		url:    url,
+		ctx:    ctx,
+		cancel: cancel,
+		out:    make(chan entity.Message),
+		feeds:  make(map[string]*feedRoute),
+		done:   make(chan struct{}),
+		gate:   limiter.NewGate(0),
+		quota:  f.quota(url.ID, ""),
+	}
+	route.fair = limiter.NewFairQueue(route.out, route.gate)
+	route.breaker = sender.NewBreaker(url.Breaker, func(state string) {
+		f.logger.Log(ctx, "breaker of url %v is %v", url.ID, state)
+		f.metrics.BreakerState(url.ID, state)
+	})
+	f.metrics.BreakerState(url.ID, sender.BreakerClosed)
+	route.adaptive = sender.NewAdaptive(url.Adaptive, func(rate float64) {
+		f.logger.Log(ctx, "rate of url %v is adapted to %.2f of limits", url.ID, rate)
+		f.adapt(route)
+	})
+	querySender := f.sendersFabric.NewQuerySender()
+	querySender.Init(sender.Options{
+		Timeout:  time.Second * time.Duration(params.TimeOut),
+		PoolSize: params.PoolSize,
+		Logger:   f.logger,
+		Metrics:  f.metrics,
+		Requeue: func(ctx context.Context, msg entity.Message) error {
+			return f.requeue(ctx, route, msg)
+		},
+		Breaker:     route.breaker,
+		Adaptive:    route.adaptive,
+		DeadLetters: f.deadLetters,
+	})
+	go func() {
+		defer close(route.out)
+		route.fair.Run(ctx)
+	}()
+	go func() {
+		defer close(route.done)
+		querySender.Send(ctx, url, route.out)
+	}()
+	return route, nil
+}
+
+func (f *FanoutInteractor) Breaker(ctx context.Context, urlID string) (sender.BreakerStatus, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	route, ok := f.routes[urlID]
+	if !ok {
+		return sender.BreakerStatus{}, ErrNotFound
+	}
+	return route.breaker.Status(), nil
+}
+
+// adapt applies current rate of url to limits of url and its feeds.
+func (f *FanoutInteractor) adapt(route *urlRoute) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fr := range route.feeds {
+		lim, _ := fr.limit(f.now())
+		fr.limiter.SetLimit(route.adaptive.Limit(lim)) // configured limit of metrics is not changed
+	}
+	lim, _ := strconv.Atoi(route.url.Limit)
+	route.gate.SetLimit(route.adaptive.Limit(lim))
+}
+
+// Rate returns current rate of url with configured and effective limits of url and its feeds.
+func (f *FanoutInteractor) Rate(ctx context.Context, urlID string) (*Rate, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	route, ok := f.routes[urlID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	lim, _ := strconv.Atoi(route.url.Limit)
+	rate := &Rate{AdaptiveStatus: route.adaptive.Status(), Limit: lim, Effective: route.adaptive.Limit(lim)}
+	now := f.now()
+	for _, feed := range route.url.Feeds {
+		fr, ok := route.feeds[feed.ID]
+		if !ok {
+			continue
+		}
+		lim, profile := fr.limit(now)
+		rate.Feeds = append(rate.Feeds, FeedRate{ID: feed.ID, Profile: profile, Limit: lim, Effective: route.adaptive.Limit(lim)})
+	}
+	return rate, nil
+}
+
+// ReplayDeadLetters queues dead letters again to limiters, letters of unknown urls and feeds or not accepted
+// by full limiter queue stay in store.
+func (f *FanoutInteractor) ReplayDeadLetters(ctx context.Context) (int, error) {
+	if f.deadLetters == nil {
+		return 0, ErrNoDeadLetters
+	}
+	letters, err := f.deadLetters.List()
+	if err != nil {
+		return 0, err
+	}
+	var replayed []string
+	for _, letter := range letters {
+		f.mu.RLock()
+		route, ok := f.routes[letter.URLID]
+		f.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		msg := letter.Message
+		msg.Attempt, msg.Seq = 0, 0
+		if err := f.requeue(ctx, route, msg); err != nil {
+			f.logger.Log(ctx, "can't replay dead letter %v: %v", letter.ID, err)
+			continue
+		}
+		replayed = append(replayed, letter.ID)
+	}
+	if len(replayed) == 0 {
+		return 0, nil
+	}
+	return len(replayed), f.deadLetters.Delete(replayed...)
 }
 
-func (f *FanoutInteractor) Fanout(ctx context.Context, id string) error {
-	limiters, ok := f.feeds[id]
+// requeue queues message again to limiter of its feed for url, retry is taken from quotas with reject policy.
+func (f *FanoutInteractor) requeue(ctx context.Context, route *urlRoute, msg entity.Message) error {
+	f.mu.RLock()
+	fr, ok := route.feeds[msg.FeedID]
+	f.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	quotas := []*limiter.Quota{route.quota, fr.quota}
+	if retry, ok := takeQuotas(quotas, time.Now()); !ok {
+		return &limiter.QuotaError{RetryAfter: retry}
+	}
+	err := fr.limiter.Push(ctx, msg)
+	if err != nil {
+		refundQuotas(quotas)
+	}
+	return err
+}
+
+// stopURL closes inputs of url limiters, waits until they send queued items and stops the sender.
+// Metrics of url and its feeds are removed after that if url is removed from params.
+func (f *FanoutInteractor) stopURL(route *urlRoute, removed bool) {
+	urlID, feedIDs := route.url.ID, make([]string, 0, len(route.feeds))
+	for id, fr := range route.feeds {
+		fr.limiter.Close()
+		feedIDs = append(feedIDs, id)
+	}
+	go func() {
+		route.limiters.Wait()
+		route.fair.Close() // fair queue sends the rest of queries and closes out
+		<-route.done
+		route.cancel()
+		if removed {
+			for _, feedID := range feedIDs {
+				f.metrics.Remove(urlID, feedID)
+			}
+			f.metrics.Remove(urlID, "")
+		}
+	}()
+}
+
+func (f *FanoutInteractor) startFeed(route *urlRoute, feed entity.Feed) (*feedRoute, error) {
+	fr := &feedRoute{feed: feed, quota: f.quota(route.url.ID, feed.ID), stopped: make(chan struct{})}
+	if feed.Schedule != nil {
+		var err error
+		if fr.schedule, err = newSchedule(feed.Schedule); err != nil {
+			return nil, pkgerrors.Wrapf(err, ErrStartFeed, feed.ID, route.url.ID)
+		}
+	}
+	lim, profile := fr.limit(f.now())
+	qpsLimiter, err := f.qpsLimiterFabric.NewQPSLimiter(limiter.Config{
+		URLID:      route.url.ID,
+		FeedID:     feed.ID,
+		Metrics:    f.metrics,
+		Algorithm:  feed.Algorithm,
+		Burst:      feed.Burst,
+		Overflow:   limiter.Overflow{Policy: feed.Overflow, Timeout: time.Duration(feed.BlockTimeout) * time.Millisecond},
+		Durable:    feed.Durable,
+		Starvation: feed.Starvation,
+		Gates:      []limiter.Barrier{f.feedGate(feed.ID), f.global, route.quota, fr.quota},
+		Logger:     f.logger,
+	})
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, ErrStartFeed, feed.ID, route.url.ID)
+	}
+	flow := route.fair.Add(feed.Share)
+	qpsLimiter.Init(flow.In(), route.adaptive.Limit(lim))
+	f.metrics.Limit(route.url.ID, feed.ID, lim)
+	route.limiters.Add(1)
+	go func() {
+		defer route.limiters.Done()
+		defer route.fair.Remove(flow)
+		defer close(fr.stopped)
+		qpsLimiter.DoLimiting(route.ctx)
+	}()
+	fr.limiter, fr.flow, fr.profile = qpsLimiter, flow, profile
+	return fr, nil
+}
+
+// urlChanged reports whether url needs another sender (changed feeds, limit and quota are applied to running limiters).
+func urlChanged(old, new entity.URL) bool {
+	old.Feeds, new.Feeds = nil, nil
+	old.Limit, new.Limit = "", ""
+	old.Quota, new.Quota = nil, nil
+	return !reflect.DeepEqual(old, new)
+}
+
+// limiterChanged reports whether feed needs another limiter (changed limit is applied to running one).
+func limiterChanged(old, new entity.Feed) bool {
+	return old.Algorithm != new.Algorithm || old.Burst != new.Burst ||
+		old.Overflow != new.Overflow || old.BlockTimeout != new.BlockTimeout || old.Durable != new.Durable ||
+		old.Starvation != new.Starvation
+}
+
+// Fanout queues query to limiters of urls of its feed chosen by routing settings of feed (or to urls listed
+// in URLIDs of query). If queue of any url with reject overflow policy is full, *limiter.OverflowError with
+// the longest retry time is returned. Query is not queued for url whose quota or quota of feed for it with reject
+// policy is exhausted, *limiter.QuotaError with the longest retry time is returned then; query queued for url
+// is taken from its quotas with reject policy at once. If query is still queued for other urls, the error
+// is wrapped in *PartialError listing accepted and rejected urls.
+// Fanout of sync feed waits for results of urls and returns them, result is nil for async feed.
+func (f *FanoutInteractor) Fanout(ctx context.Context, msg entity.Message) (*entity.FanoutResult, error) {
+	f.mu.RLock()
+	feed, ok := f.feeds[msg.FeedID]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	f.metrics.Fanout(msg.FeedID)
+	var targets []fanoutTarget
+	if len(msg.URLIDs) > 0 {
+		targets = only(feed.targets, msg.URLIDs)
+	} else {
+		targets = route(feed.targets, msg)
+	}
+	if feed.sync {
+		return f.fanoutSync(ctx, feed, targets, msg), nil
+	}
+	var (
+		overflow           *limiter.OverflowError
+		quota              *limiter.QuotaError
+		accepted, rejected []string
+	)
+	now := time.Now()
+	for _, target := range targets {
+		if retry, ok := target.take(now); !ok {
+			if quota == nil || retry > quota.RetryAfter {
+				quota = &limiter.QuotaError{RetryAfter: retry}
+			}
+			rejected = append(rejected, target.urlID)
+			continue
+		}
+		msg.Priority = priority(msg, target.feed)
+		err := target.limiter.Push(ctx, msg)
+		if err != nil {
+			target.refund()
+		}
+		switch e := err.(type) {
+		case nil:
+			accepted = append(accepted, target.urlID)
+		case *limiter.OverflowError:
+			if overflow == nil || e.RetryAfter > overflow.RetryAfter {
+				overflow = e
+			}
+			rejected = append(rejected, target.urlID)
+		default: // dropped query and closed (removed by reload) limiter are skipped
+			if err == ctx.Err() { // canceled while waiting for place in queue
+				return nil, err
+			}
+		}
+	}
+	var err error
+	switch {
+	case quota != nil:
+		err = quota
+	case overflow != nil:
+		err = overflow
+	default:
+		return nil, nil
+	}
+	if len(accepted) > 0 {
+		return nil, &PartialError{Err: err, Accepted: accepted, Rejected: rejected}
+	}
+	return nil, err
+}
+
+// fanoutSync queues query to limiters of routed urls of feed and waits for their results until deadline,
+// url which has not replied has error result.
+func (f *FanoutInteractor) fanoutSync(ctx context.Context, feed *fanoutFeed, targets []fanoutTarget, msg entity.Message) *entity.FanoutResult {
+	replies := make(chan entity.Result, len(targets)) // sender replies once per url, so reply never blocks
+	msg.Reply = func(r entity.Result) {
+		replies <- r
+	}
+	results := make(map[string]entity.Result, len(targets))
+	now := time.Now()
+	for _, target := range targets {
+		if retry, ok := target.take(now); !ok {
+			results[target.urlID] = entity.Result{URLID: target.urlID, Error: (&limiter.QuotaError{RetryAfter: retry}).Error()}
+			continue
+		}
+		msg.Priority = priority(msg, target.feed)
+		if err := target.limiter.Push(ctx, msg); err != nil {
+			target.refund()
+			results[target.urlID] = entity.Result{URLID: target.urlID, Error: err.Error()}
+		}
+	}
+	timer := time.NewTimer(feed.deadline)
+	defer timer.Stop()
+wait:
+	for len(results) < len(targets) {
+		select {
+		case r := <-replies:
+			results[r.URLID] = r
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	fr := &entity.FanoutResult{}
+	delivered := 0
+	for _, target := range targets {
+		r, ok := results[target.urlID]
+		if !ok {
+			r = entity.Result{URLID: target.urlID, Error: ErrDeadline}
+		}
+		if r.OK() {
+			delivered++
+		}
+		fr.Results = append(fr.Results, r)
+	}
+	switch feed.success {
+	case entity.SuccessAny:
+		fr.Success = delivered > 0
+	case entity.SuccessQuorum:
+		fr.Success = delivered > len(targets)/2
+	default:
+		fr.Success = delivered == len(targets)
+	}
+	return fr
+}
+
+func (f *FanoutInteractor) SetLimit(ctx context.Context, urlID, feedID string, limit int) error {
+	if limit <= 0 {
+		return ErrBadLimit
+	}
+	if _, ok := f.paramsRepo.(entity.FanParamWriter); ok { // stored limit is applied by reload and kept by next ones
+		err := f.change(ctx, func(params *entity.FanParam) error {
+			i := findURL(params, urlID)
+			if i < 0 {
+				return pkgerrors.Wrapf(entity.ErrNotFound, "url %v", urlID)
+			}
+			j := findFeed(params.URLs[i], feedID)
+			if j < 0 {
+				return pkgerrors.Wrapf(entity.ErrNotFound, "feed %v of url %v", feedID, urlID)
+			}
+			params.URLs[i].Feeds[j].Limit = strconv.Itoa(limit)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		f.logger.Log(ctx, "limit of feed %v for url %v changed to %v", feedID, urlID, limit)
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	route, ok := f.routes[urlID]
+	if !ok {
+		return ErrNotFound
+	}
+	fr, ok := route.feeds[feedID]
 	if !ok {
-		return errors.New("not found")
+		return ErrNotFound
 	}
-	for _, limiter := range limiters {
-		limiter <- id
+	fr.override = strconv.Itoa(limit)
+	if fr.profile == DefaultProfile { // limit of active window of schedule is kept
+		fr.limiter.SetLimit(route.adaptive.Limit(limit))
+		f.metrics.Limit(urlID, feedID, limit)
 	}
+	f.logger.Log(ctx, "limit of feed %v for url %v changed to %v until its stored limit is changed", feedID, urlID, limit)
 	return nil
 }
@@ -2,10 +2,55 @@ package fanouter
 
 import (
 	"context"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
 )
 
-// Fanouter is abstract object receiving incoming feed id and transmitting multi queries to external urls.
+// Fanouter is abstract object receiving incoming feed query and transmitting multi queries to external urls.
 type Fanouter interface {
-	Fanout(ctx context.Context, id string) error
+	// Fanout transmits query to urls of its feed, result of urls is returned for synchronous feed only.
+	Fanout(ctx context.Context, msg entity.Message) (*entity.FanoutResult, error)
 	Init(ctx context.Context) error
+	// SetLimit changes outgoing qps of feed for external url without restart (out of windows of feed schedule).
+	// Limit is stored if store of parameters is writable, otherwise it is kept by reloads until stored limit is changed.
+	SetLimit(ctx context.Context, urlID, feedID string, limit int) error
+	// Reload reloads fanout parameters and applies the difference to running senders and limiters, invalid parameters
+	// are not applied (*entity.ParamError lists their problems).
+	Reload(ctx context.Context) error
+	// Breaker returns state of circuit breaker of external url.
+	Breaker(ctx context.Context, urlID string) (sender.BreakerStatus, error)
+	// Rate returns rate of external url adapted to its responses and effective limits of url and its feeds.
+	Rate(ctx context.Context, urlID string) (*Rate, error)
+	// Quotas returns usage of quotas of urls and feeds for urls in current period.
+	Quotas(ctx context.Context) ([]Quota, error)
+	// URLs returns stored urls with their feeds.
+	URLs(ctx context.Context) ([]entity.URL, error)
+	URL(ctx context.Context, id string) (*entity.URL, error)
+	// CreateURL, UpdateURL, DeleteURL and the same methods of feeds of url change stored parameters and apply them
+	// like Reload, changed parameters are not stored if they are invalid. ErrReadOnly is returned if store
+	// of parameters can't be changed.
+	CreateURL(ctx context.Context, url entity.URL) error
+	UpdateURL(ctx context.Context, url entity.URL) error
+	DeleteURL(ctx context.Context, id string) error
+	CreateFeed(ctx context.Context, urlID string, feed entity.Feed) error
+	UpdateFeed(ctx context.Context, urlID string, feed entity.Feed) error
+	DeleteFeed(ctx context.Context, urlID, feedID string) error
+	// ReplayDeadLetters queues stored dead letters again to limiters of their feeds for their urls and deletes them.
+	ReplayDeadLetters(ctx context.Context) (int, error)
+}
+
+// Rate is current rate of url with limits of url and its feeds: configured ones and effective ones multiplied by rate.
+type Rate struct {
+	sender.AdaptiveStatus
+	Limit     int        `json:"limit"` // limit of url across its feeds, 0 if not limited
+	Effective int        `json:"effective"`
+	Feeds     []FeedRate `json:"feeds"`
+}
+
+type FeedRate struct {
+	ID        string `json:"id"`
+	Profile   string `json:"profile"` // active limit profile of schedule of feed
+	Limit     int    `json:"limit"`
+	Effective int    `json:"effective"`
 }
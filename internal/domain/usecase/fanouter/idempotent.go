@@ -0,0 +1,123 @@
+package fanouter
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+// HeaderIdempotencyKey is header of incoming query with its idempotency key.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+var _ Fanouter = (*IdempotentFanouter)(nil)
+
+// outcome is outcome of fanout of query with idempotency key, done is closed when fanout returns.
+type outcome struct {
+	done    chan struct{}
+	result  *entity.FanoutResult
+	err     error
+	pending []string // ids of urls which rejected query queued for other urls
+	expires time.Time
+}
+
+// IdempotentFanouter suppresses duplicates of incoming queries of feed with the same idempotency key during ttl:
+// outcome of the first query is returned for repeats instead of fanout. Only outcome of accepted query is kept,
+// so query failed with error may be repeated. Query accepted by some urls and rejected by others is repeated only
+// to rejected urls. Queries without key are not deduplicated.
+type IdempotentFanouter struct {
+	Fanouter
+	param    string // query param with idempotency key used if there is no header
+	ttl      time.Duration
+	mu       sync.Mutex
+	outcomes map[string]*outcome // feed id and key -> outcome
+	sweep    time.Time           // time of next removing of expired outcomes
+}
+
+func NewIdempotentFanouter(fanouter Fanouter, param string, ttl time.Duration) *IdempotentFanouter {
+	return &IdempotentFanouter{Fanouter: fanouter, param: param, ttl: ttl, outcomes: make(map[string]*outcome)}
+}
+
+func (f *IdempotentFanouter) Fanout(ctx context.Context, msg entity.Message) (*entity.FanoutResult, error) {
+	key := f.key(msg)
+	if key == "" {
+		return f.Fanouter.Fanout(ctx, msg)
+	}
+	key = msg.FeedID + "\x00" + key
+	for {
+		f.mu.Lock()
+		now := time.Now()
+		f.removeExpired(now)
+		o, ok := f.outcomes[key]
+		if !ok || (!o.expires.IsZero() && now.After(o.expires)) {
+			o = &outcome{done: make(chan struct{})}
+			f.outcomes[key] = o
+			f.mu.Unlock()
+			return f.fanout(ctx, key, o, msg)
+		}
+		if len(o.pending) > 0 { // fanout is done and query is accepted by some urls: repeat it to others
+			msg.URLIDs = o.pending
+			o = &outcome{done: make(chan struct{})}
+			f.outcomes[key] = o
+			f.mu.Unlock()
+			return f.fanout(ctx, key, o, msg)
+		}
+		f.mu.Unlock()
+		select { // the same query is being fanned out now
+		case <-o.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if o.err == nil {
+			return o.result, nil
+		}
+	}
+}
+
+// fanout fans out the first query with key (or its repeat to urls rejected it) and keeps its outcome if query
+// is accepted by any url, urls rejected it are kept to repeat query to them.
+func (f *IdempotentFanouter) fanout(ctx context.Context, key string, o *outcome, msg entity.Message) (*entity.FanoutResult, error) {
+	o.result, o.err = f.Fanouter.Fanout(ctx, msg)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer close(o.done) // under lock, so waiting repeat sees pending urls
+	switch e := o.err.(type) {
+	case nil:
+	case *PartialError:
+		o.pending = e.Rejected
+	default:
+		if len(msg.URLIDs) == 0 {
+			delete(f.outcomes, key)
+			return o.result, o.err
+		}
+		o.pending = msg.URLIDs // repeat is rejected again, query is still accepted by other urls
+	}
+	o.expires = time.Now().Add(f.ttl)
+	return o.result, o.err
+}
+
+// key returns idempotency key of query from header or query param.
+func (f *IdempotentFanouter) key(msg entity.Message) string {
+	if key := http.Header(msg.Header).Get(HeaderIdempotencyKey); key != "" {
+		return key
+	}
+	if f.param != "" && len(msg.Query[f.param]) > 0 {
+		return msg.Query[f.param][0]
+	}
+	return ""
+}
+
+// removeExpired removes expired outcomes not often then once per ttl. Must be called under lock.
+func (f *IdempotentFanouter) removeExpired(now time.Time) {
+	if now.Before(f.sweep) {
+		return
+	}
+	for key, o := range f.outcomes {
+		if !o.expires.IsZero() && now.After(o.expires) {
+			delete(f.outcomes, key)
+		}
+	}
+	f.sweep = now.Add(f.ttl)
+}
@@ -0,0 +1,164 @@
+package fanouter
+
+import (
+	"context"
+	"errors"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+var (
+	ErrReadOnly = errors.New("fanout parameters store is read-only")
+	ErrNoID     = errors.New("id must not be empty")
+)
+
+// URLs returns stored urls with their feeds.
+func (f *FanoutInteractor) URLs(ctx context.Context) ([]entity.URL, error) {
+	params, err := f.paramsRepo.Load()
+	if err != nil {
+		return nil, err
+	}
+	return params.URLs, nil
+}
+
+// URL returns stored url with its feeds.
+func (f *FanoutInteractor) URL(ctx context.Context, id string) (*entity.URL, error) {
+	urls, err := f.URLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range urls {
+		if url.ID == id {
+			return &url, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (f *FanoutInteractor) CreateURL(ctx context.Context, url entity.URL) error {
+	if url.ID == "" {
+		return ErrNoID
+	}
+	return f.change(ctx, func(params *entity.FanParam) error {
+		if findURL(params, url.ID) >= 0 {
+			return pkgerrors.Wrapf(entity.ErrExists, "url %v", url.ID)
+		}
+		params.URLs = append(params.URLs, url)
+		return nil
+	})
+}
+
+// UpdateURL replaces settings of url, its feeds are kept if url has no feeds.
+func (f *FanoutInteractor) UpdateURL(ctx context.Context, url entity.URL) error {
+	return f.change(ctx, func(params *entity.FanParam) error {
+		i := findURL(params, url.ID)
+		if i < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "url %v", url.ID)
+		}
+		if url.Feeds == nil {
+			url.Feeds = params.URLs[i].Feeds
+		}
+		params.URLs[i] = url
+		return nil
+	})
+}
+
+func (f *FanoutInteractor) DeleteURL(ctx context.Context, id string) error {
+	return f.change(ctx, func(params *entity.FanParam) error {
+		i := findURL(params, id)
+		if i < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "url %v", id)
+		}
+		params.URLs = append(params.URLs[:i], params.URLs[i+1:]...)
+		return nil
+	})
+}
+
+func (f *FanoutInteractor) CreateFeed(ctx context.Context, urlID string, feed entity.Feed) error {
+	if feed.ID == "" {
+		return ErrNoID
+	}
+	return f.change(ctx, func(params *entity.FanParam) error {
+		i := findURL(params, urlID)
+		if i < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "url %v", urlID)
+		}
+		if findFeed(params.URLs[i], feed.ID) >= 0 {
+			return pkgerrors.Wrapf(entity.ErrExists, "feed %v of url %v", feed.ID, urlID)
+		}
+		params.URLs[i].Feeds = append(params.URLs[i].Feeds, feed)
+		return nil
+	})
+}
+
+func (f *FanoutInteractor) UpdateFeed(ctx context.Context, urlID string, feed entity.Feed) error {
+	return f.change(ctx, func(params *entity.FanParam) error {
+		i := findURL(params, urlID)
+		if i < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "url %v", urlID)
+		}
+		j := findFeed(params.URLs[i], feed.ID)
+		if j < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "feed %v of url %v", feed.ID, urlID)
+		}
+		params.URLs[i].Feeds[j] = feed
+		return nil
+	})
+}
+
+func (f *FanoutInteractor) DeleteFeed(ctx context.Context, urlID, feedID string) error {
+	return f.change(ctx, func(params *entity.FanParam) error {
+		i := findURL(params, urlID)
+		if i < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "url %v", urlID)
+		}
+		j := findFeed(params.URLs[i], feedID)
+		if j < 0 {
+			return pkgerrors.Wrapf(entity.ErrNotFound, "feed %v of url %v", feedID, urlID)
+		}
+		feeds := params.URLs[i].Feeds
+		params.URLs[i].Feeds = append(feeds[:j], feeds[j+1:]...)
+		return nil
+	})
+}
+
+// change applies change to stored parameters and persists them if the whole result is valid, then applies them
+// to running senders and limiters like Reload.
+func (f *FanoutInteractor) change(ctx context.Context, change func(params *entity.FanParam) error) error {
+	w, ok := f.paramsRepo.(entity.FanParamWriter)
+	if !ok {
+		return ErrReadOnly
+	}
+	err := w.Update(func(params *entity.FanParam) error {
+		if err := change(params); err != nil {
+			return err
+		}
+		return Validate(params, f.qpsLimiterFabric)
+	})
+	if err != nil {
+		return err
+	}
+	return f.Reload(ctx)
+}
+
+// findURL returns index of url with id, -1 if there is no such url.
+func findURL(params *entity.FanParam, id string) int {
+	for i, url := range params.URLs {
+		if url.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// findFeed returns index of feed of url with id, -1 if url has no such feed.
+func findFeed(url entity.URL, id string) int {
+	for i, feed := range url.Feeds {
+		if feed.ID == id {
+			return i
+		}
+	}
+	return -1
+}
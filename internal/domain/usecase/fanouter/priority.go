@@ -0,0 +1,37 @@
+package fanouter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	// HeaderPriority is header of incoming query with its priority class.
+	HeaderPriority = "X-Priority"
+	// ParamPriority is query param with priority class of query used if there is no header.
+	ParamPriority = "priority"
+)
+
+// priority returns priority class of query from header or query param, priority of feed for url is used
+// if query has no valid class.
+func priority(msg entity.Message, feed entity.Feed) string {
+	if class, ok := priorityClass(http.Header(msg.Header).Get(HeaderPriority)); ok {
+		return class
+	}
+	if len(msg.Query[ParamPriority]) > 0 {
+		if class, ok := priorityClass(msg.Query[ParamPriority][0]); ok {
+			return class
+		}
+	}
+	return feed.Priority
+}
+
+func priorityClass(s string) (string, bool) {
+	switch class := strings.ToLower(strings.TrimSpace(s)); class {
+	case entity.PriorityHigh, entity.PriorityNormal, entity.PriorityLow:
+		return class, true
+	}
+	return "", false
+}
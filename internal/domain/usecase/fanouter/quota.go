@@ -0,0 +1,209 @@
+package fanouter
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+)
+
+const (
+	ErrQuota      = "bad quota of url %v feed %v"
+	ErrLoadQuotas = "can't load quota usage"
+	ErrSaveQuotas = "can't save quota usage"
+)
+
+// quotaInterval is interval of saving quota usage and updating remaining quota metrics.
+const quotaInterval = time.Second
+
+// quotaKey is key of quota of url (feedID is empty) or of feed for url.
+type quotaKey struct {
+	urlID, feedID string
+}
+
+// Quota is usage of quota of url (FeedID is empty) or of feed for url.
+type Quota struct {
+	URLID  string `json:"urlid"`
+	FeedID string `json:"feedid,omitempty"`
+	limiter.QuotaStatus
+}
+
+// WithQuotas sets store of quota usage surviving restarts, it must be set before Init.
+func (f *FanoutInteractor) WithQuotas(quotas entity.QuotaRepo) *FanoutInteractor {
+	f.quotaRepo = quotas
+	return f
+}
+
+// loadQuotas loads usage saved before restart, it is restored when quotas are set.
+func (f *FanoutInteractor) loadQuotas() error {
+	f.saved = make(map[quotaKey]entity.QuotaUsage)
+	if f.quotaRepo == nil {
+		return nil
+	}
+	usage, err := f.quotaRepo.Load()
+	if err != nil {
+		return pkgerrors.Wrapf(err, ErrLoadQuotas)
+	}
+	for _, u := range usage {
+		f.saved[quotaKey{u.URLID, u.FeedID}] = u
+	}
+	return nil
+}
+
+// quota returns quota of url (feedID is empty) or of feed for url, quota keeps its usage when route is restarted.
+func (f *FanoutInteractor) quota(urlID, feedID string) *limiter.Quota {
+	key := quotaKey{urlID, feedID}
+	q, ok := f.quotas[key]
+	if !ok {
+		q = limiter.NewQuota()
+		f.quotas[key] = q
+	}
+	return q
+}
+
+// applyQuota sets settings of quota and restores its usage saved before restart. Must be called under lock.
+func (f *FanoutInteractor) applyQuota(urlID, feedID string, cfg *entity.Quota) {
+	key := quotaKey{urlID, feedID}
+	q := f.quota(urlID, feedID)
+	if err := q.Set(cfg); err != nil {
+		f.logger.Log(f.ctx, pkgerrors.Wrapf(err, ErrQuota, urlID, feedID))
+	}
+	if u, ok := f.saved[key]; ok {
+		q.Restore(u.Start, u.Used)
+		delete(f.saved, key)
+	}
+}
+
+// pruneQuotas forgets quotas of urls and feeds for urls removed from params, so their usage is not saved and
+// reported anymore. Must be called under lock.
+func (f *FanoutInteractor) pruneQuotas(params *entity.FanParam) {
+	keys := make(map[quotaKey]bool)
+	for _, url := range params.URLs {
+		keys[quotaKey{url.ID, ""}] = true
+		for _, feed := range url.Feeds {
+			keys[quotaKey{url.ID, feed.ID}] = true
+		}
+	}
+	for key := range f.quotas {
+		if !keys[key] {
+			delete(f.quotas, key)
+		}
+	}
+}
+
+// take takes query from quotas of url and of feed for url with reject policy, returns false and time until
+// exhausted quota is restored if any of them rejects query (query taken from others is returned then).
+func (t fanoutTarget) take(now time.Time) (time.Duration, bool) {
+	return takeQuotas(t.quotas, now)
+}
+
+// refund returns query taken from quotas of target which is not queued.
+func (t fanoutTarget) refund() {
+	refundQuotas(t.quotas)
+}
+
+func takeQuotas(quotas []*limiter.Quota, now time.Time) (time.Duration, bool) {
+	for i, q := range quotas {
+		if retry, ok := q.Take(now); !ok {
+			refundQuotas(quotas[:i])
+			return retry, false
+		}
+	}
+	return 0, true
+}
+
+func refundQuotas(quotas []*limiter.Quota) {
+	for _, q := range quotas {
+		q.Refund()
+	}
+}
+
+// Quotas returns usage of quotas of running urls and feeds for urls.
+func (f *FanoutInteractor) Quotas(ctx context.Context) ([]Quota, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	now := time.Now()
+	quotas := []Quota{}
+	for id, route := range f.routes {
+		if status, ok := route.quota.Status(now); ok {
+			quotas = append(quotas, Quota{URLID: id, QuotaStatus: status})
+		}
+		for feedID, fr := range route.feeds {
+			if status, ok := fr.quota.Status(now); ok {
+				quotas = append(quotas, Quota{URLID: id, FeedID: feedID, QuotaStatus: status})
+			}
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].URLID != quotas[j].URLID {
+			return quotas[i].URLID < quotas[j].URLID
+		}
+		return quotas[i].FeedID < quotas[j].FeedID
+	})
+	return quotas, nil
+}
+
+// runQuotas saves changed quota usage and updates remaining quota metrics every interval until ctx is done,
+// usage is saved once more then.
+func (f *FanoutInteractor) runQuotas(ctx context.Context) {
+	defer f.jobs.Done()
+	ticker := time.NewTicker(quotaInterval)
+	defer ticker.Stop()
+	var last []entity.QuotaUsage
+	for {
+		select {
+		case <-ctx.Done():
+			f.saveQuotas(ctx, last)
+			return
+		case <-ticker.C:
+			last = f.saveQuotas(ctx, last)
+		}
+	}
+}
+
+// saveQuotas saves usage of limited quotas if it differs from last saved one, returns saved usage.
+func (f *FanoutInteractor) saveQuotas(ctx context.Context, last []entity.QuotaUsage) []entity.QuotaUsage {
+	now := time.Now()
+	var usage []entity.QuotaUsage
+	f.mu.RLock()
+	for key, q := range f.quotas {
+		status, ok := q.Status(now)
+		if !ok {
+			continue
+		}
+		f.metrics.QuotaRemaining(key.urlID, key.feedID, status.Remaining)
+		start, used := q.Usage()
+		usage = append(usage, entity.QuotaUsage{URLID: key.urlID, FeedID: key.feedID, Start: start, Used: used})
+	}
+	f.mu.RUnlock()
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].URLID != usage[j].URLID {
+			return usage[i].URLID < usage[j].URLID
+		}
+		return usage[i].FeedID < usage[j].FeedID
+	})
+	if f.quotaRepo == nil || equalUsage(usage, last) {
+		return last
+	}
+	if err := f.quotaRepo.Save(usage); err != nil {
+		f.logger.Log(ctx, pkgerrors.Wrapf(err, ErrSaveQuotas))
+		return last
+	}
+	return usage
+}
+
+func equalUsage(a, b []entity.QuotaUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].URLID != b[i].URLID || a[i].FeedID != b[i].FeedID || a[i].Used != b[i].Used || !a[i].Start.Equal(b[i].Start) {
+			return false
+		}
+	}
+	return true
+}
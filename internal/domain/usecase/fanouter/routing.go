@@ -0,0 +1,95 @@
+package fanouter
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+// route returns targets of feed receiving query: targets with matched condition and passed sampling,
+// one target of every group is picked by weight.
+func route(targets []fanoutTarget, msg entity.Message) []fanoutTarget {
+	routed := make([]fanoutTarget, 0, len(targets))
+	groups := make(map[string][]fanoutTarget)
+	var order []string // groups in order of targets
+	for _, target := range targets {
+		if !matches(target.feed.Match, msg) {
+			continue
+		}
+		if group := target.feed.Group; group != "" {
+			if _, ok := groups[group]; !ok {
+				order = append(order, group)
+			}
+			groups[group] = append(groups[group], target)
+			continue
+		}
+		routed = append(routed, target)
+	}
+	for _, group := range order {
+		routed = append(routed, pick(groups[group]))
+	}
+	sampled := routed[:0]
+	for _, target := range routed {
+		if target.feed.Sample == 0 || rand.Float64()*100 < target.feed.Sample { //nolint:gosec
+			sampled = append(sampled, target)
+		}
+	}
+	return sampled
+}
+
+// only returns targets of urls with ids.
+func only(targets []fanoutTarget, urlIDs []string) []fanoutTarget {
+	ids := make(map[string]bool, len(urlIDs))
+	for _, id := range urlIDs {
+		ids[id] = true
+	}
+	var selected []fanoutTarget
+	for _, target := range targets {
+		if ids[target.urlID] {
+			selected = append(selected, target)
+		}
+	}
+	return selected
+}
+
+// matches reports whether query meets condition, nil condition is met by any query.
+func matches(match *entity.Match, msg entity.Message) bool {
+	if match == nil {
+		return true
+	}
+	for name, value := range match.Query {
+		if url.Values(msg.Query).Get(name) != value {
+			return false
+		}
+	}
+	for name, value := range match.Headers {
+		if http.Header(msg.Header).Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// pick picks one of targets with probability proportional to weight.
+func pick(targets []fanoutTarget) fanoutTarget {
+	total := 0
+	for _, target := range targets {
+		total += weight(target.feed)
+	}
+	n := rand.Intn(total) //nolint:gosec
+	for _, target := range targets {
+		if n -= weight(target.feed); n < 0 {
+			return target
+		}
+	}
+	return targets[len(targets)-1]
+}
+
+func weight(feed entity.Feed) int {
+	if feed.Weight <= 0 {
+		return 1
+	}
+	return feed.Weight
+}
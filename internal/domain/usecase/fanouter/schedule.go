@@ -0,0 +1,164 @@
+package fanouter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	ErrTimezone    = "unknown timezone %v"
+	ErrWindowTime  = "bad time %q of window %v, must be HH:MM"
+	ErrWindowDay   = "bad day %q of window %v"
+	ErrWindowLimit = "limit of window %v must be positive"
+)
+
+// DefaultProfile is name of active profile of feed out of windows of its schedule.
+const DefaultProfile = "default"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// schedule is parsed schedule of feed limits.
+type schedule struct {
+	loc     *time.Location
+	windows []window
+}
+
+type window struct {
+	name     string
+	from, to int                   // minutes of day
+	days     map[time.Weekday]bool // every day if nil
+	limit    int
+}
+
+func newSchedule(cfg *entity.Schedule) (*schedule, error) {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, ErrTimezone, cfg.Timezone)
+	}
+	s := &schedule{loc: loc}
+	for _, w := range cfg.Windows {
+		name := w.Name
+		if name == "" {
+			name = w.From + "-" + w.To
+		}
+		from, err := minuteOfDay(w.From)
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, ErrWindowTime, w.From, name)
+		}
+		to, err := minuteOfDay(w.To)
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, ErrWindowTime, w.To, name)
+		}
+		limit, err := strconv.Atoi(w.Limit)
+		if err != nil || limit <= 0 {
+			return nil, pkgerrors.Errorf(ErrWindowLimit, name)
+		}
+		sw := window{name: name, from: from, to: to, limit: limit}
+		for _, d := range w.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return nil, pkgerrors.Errorf(ErrWindowDay, d, name)
+			}
+			if sw.days == nil {
+				sw.days = make(map[time.Weekday]bool)
+			}
+			sw.days[day] = true
+		}
+		s.windows = append(s.windows, sw)
+	}
+	return s, nil
+}
+
+// active returns the first window containing time t, nil if there is no such window.
+func (s *schedule) active(t time.Time) *window {
+	t = t.In(s.loc)
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	for i := range s.windows {
+		w := &s.windows[i]
+		if w.from < w.to {
+			if m >= w.from && m < w.to && w.on(day) {
+				return w
+			}
+			continue
+		}
+		if (m >= w.from && w.on(day)) || (m < w.to && w.on((day+6)%7)) { // window passing midnight
+			return w
+		}
+	}
+	return nil
+}
+
+func (w *window) on(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
+
+// minuteOfDay parses HH:MM time of day.
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// runSchedules switches limits of feeds with schedules at boundaries of minutes until ctx is done.
+func (f *FanoutInteractor) runSchedules(ctx context.Context) {
+	defer f.jobs.Done()
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		f.mu.Lock()
+		for _, route := range f.routes {
+			for _, fr := range route.feeds {
+				f.applySchedule(route, fr, f.now())
+			}
+		}
+		f.mu.Unlock()
+	}
+}
+
+// applySchedule sets limit of active profile of feed to its limiter if active profile is changed.
+// Must be called under lock.
+func (f *FanoutInteractor) applySchedule(route *urlRoute, fr *feedRoute, now time.Time) {
+	if fr.schedule == nil {
+		return
+	}
+	lim, profile := fr.limit(now)
+	if profile == fr.profile {
+		return
+	}
+	fr.profile = profile
+	fr.limiter.SetLimit(route.adaptive.Limit(lim))
+	f.metrics.Limit(route.url.ID, fr.feed.ID, lim)
+	f.logger.Log(f.ctx, "limit of feed %v for url %v is %v by profile %v", fr.feed.ID, route.url.ID, lim, profile)
+}
+
+// limit returns limit of feed in active profile and name of the profile.
+func (fr *feedRoute) limit(now time.Time) (int, string) {
+	if fr.schedule != nil {
+		if w := fr.schedule.active(now); w != nil {
+			return w.limit, w.name
+		}
+	}
+	lim, _ := strconv.Atoi(fr.feed.Limit)
+	if fr.override != "" {
+		lim, _ = strconv.Atoi(fr.override)
+	}
+	return lim, DefaultProfile
+}
@@ -0,0 +1,272 @@
+package fanouter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
+)
+
+// Load loads fanout parameters from repo and validates them, format of stored parameters is checked too if repo
+// can check it and algorithms and durable queues of feeds are checked if fabric can check them (limiter.ConfigChecker).
+// *entity.ParamError lists all found problems.
+func Load(repo entity.FanParamRepo, fabric limiter.QPSLimiterFabric) (*entity.FanParam, error) {
+	var problems []entity.Problem
+	if checker, ok := repo.(entity.FanParamChecker); ok {
+		var err error
+		if problems, err = checker.Check(); err != nil {
+			return nil, err
+		}
+	}
+	params, err := repo.Load()
+	if err != nil {
+		return nil, err
+	}
+	v := newValidator(fabric)
+	v.problems = problems
+	v.params(params)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// Validate checks fanout parameters as Load does, *entity.ParamError lists all found problems.
+func Validate(params *entity.FanParam, fabric limiter.QPSLimiterFabric) error {
+	v := newValidator(fabric)
+	v.params(params)
+	return v.err()
+}
+
+// validator collects problems of fanout parameters with json paths of invalid values.
+type validator struct {
+	problems []entity.Problem
+	limiters limiter.ConfigChecker // nil if limiter fabric can't check configs
+}
+
+func newValidator(fabric limiter.QPSLimiterFabric) *validator {
+	checker, _ := fabric.(limiter.ConfigChecker)
+	return &validator{limiters: checker}
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, entity.Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &entity.ParamError{Problems: v.problems}
+}
+
+func (v *validator) params(params *entity.FanParam) {
+	if params.TimeOut < 0 {
+		v.add("$.timeout", "must not be negative")
+	}
+	if params.PoolSize <= 0 {
+		v.add("$.poolsize", "must be positive")
+	}
+	v.limit("$.limit", params.Limit, false)
+	ids := make(map[string]string)
+	for i, feed := range params.Feeds {
+		path := fmt.Sprintf("$.feeds[%d]", i)
+		v.id(path, feed.ID, ids)
+		v.limit(path+".limit", feed.Limit, false)
+	}
+	ids = make(map[string]string)
+	for i, u := range params.URLs {
+		path := fmt.Sprintf("$.urls[%d]", i)
+		v.id(path, u.ID, ids)
+		v.url(path, u)
+	}
+	v.syncDurable(params.URLs)
+}
+
+// syncDurable checks that feeds with sync mode are not routed to durable queues: replies of queries are not stored
+// in write-ahead log, so results of such routes would never come. Mode of feed is set by its first url.
+func (v *validator) syncDurable(urls []entity.URL) {
+	modes := make(map[string]string)
+	for _, u := range urls {
+		for _, feed := range u.Feeds {
+			if _, ok := modes[feed.ID]; !ok {
+				modes[feed.ID] = feed.Mode
+			}
+		}
+	}
+	for i, u := range urls {
+		for j, feed := range u.Feeds {
+			if feed.Durable && modes[feed.ID] == entity.ModeSync {
+				v.add(fmt.Sprintf("$.urls[%d].feeds[%d].durable", i, j), "durable queue can't be used by feed with sync mode")
+			}
+		}
+	}
+}
+
+func (v *validator) url(path string, u entity.URL) {
+	switch {
+	case u.Value == "":
+		v.add(path+".value", "must not be empty")
+	case !strings.Contains(u.Value, "{{"): // templates are checked by parsing only
+		if parsed, err := url.Parse(u.Value); err != nil {
+			v.add(path+".value", "can't parse url: %v", err)
+		} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			v.add(path+".value", "must be absolute http or https url")
+		}
+	}
+	if _, err := sender.NewRequestBuilder(u); err != nil {
+		v.add(path, "%v", err)
+	}
+	v.limit(path+".limit", u.Limit, false)
+	v.retry(path+".retry", u.Retry)
+	v.breaker(path+".breaker", u.Breaker)
+	v.adaptive(path+".adaptive", u.Adaptive)
+	v.quota(path+".quota", u.Quota)
+	ids := make(map[string]string)
+	for i, feed := range u.Feeds {
+		feedPath := fmt.Sprintf("%v.feeds[%d]", path, i)
+		v.id(feedPath, feed.ID, ids)
+		v.feed(feedPath, feed)
+	}
+}
+
+func (v *validator) feed(path string, feed entity.Feed) {
+	v.limit(path+".limit", feed.Limit, true)
+	v.notNegative(path+".burst", feed.Burst)
+	if err := (limiter.Overflow{Policy: feed.Overflow}).Validate(); err != nil {
+		v.add(path+".overflow", "%v", err)
+	}
+	if v.limiters != nil {
+		if err := v.limiters.CheckAlgorithm(feed.Algorithm); err != nil {
+			v.add(path+".algorithm", "%v", err)
+		}
+		if err := v.limiters.CheckDurable(); err != nil && feed.Durable {
+			v.add(path+".durable", "%v", err)
+		}
+	}
+	v.oneOf(path+".priority", feed.Priority, entity.PriorityHigh, entity.PriorityNormal, entity.PriorityLow)
+	v.oneOf(path+".mode", feed.Mode, entity.ModeAsync, entity.ModeSync)
+	v.oneOf(path+".success", feed.Success, entity.SuccessAll, entity.SuccessAny, entity.SuccessQuorum)
+	v.notNegative(path+".blocktimeout", feed.BlockTimeout)
+	v.notNegative(path+".starvation", feed.Starvation)
+	v.notNegative(path+".share", feed.Share)
+	v.notNegative(path+".deadline", feed.Deadline)
+	v.notNegative(path+".weight", feed.Weight)
+	if feed.Sample < 0 || feed.Sample > 100 {
+		v.add(path+".sample", "must be percentage from 0 to 100")
+	}
+	if feed.Schedule != nil {
+		if _, err := newSchedule(feed.Schedule); err != nil {
+			v.add(path+".schedule", "%v", err)
+		}
+	}
+	v.quota(path+".quota", feed.Quota)
+}
+
+// id checks that id is set and is not used by other item with paths of items by ids.
+func (v *validator) id(path, id string, ids map[string]string) {
+	if id == "" {
+		v.add(path+".id", "must not be empty")
+		return
+	}
+	if other, ok := ids[id]; ok {
+		v.add(path+".id", "duplicate id %q of %v", id, other)
+		return
+	}
+	ids[id] = path
+}
+
+// limit checks qps limit: positive integer if it is required, empty (no limit) or not negative integer otherwise.
+func (v *validator) limit(path, s string, required bool) {
+	if s == "" && !required {
+		return
+	}
+	limit, err := strconv.Atoi(s)
+	switch {
+	case err != nil:
+		v.add(path, "must be integer, got %q", s)
+	case required && limit <= 0:
+		v.add(path, "must be positive")
+	case limit < 0:
+		v.add(path, "must not be negative")
+	}
+}
+
+func (v *validator) retry(path string, cfg *entity.Retry) {
+	if cfg == nil {
+		return
+	}
+	v.notNegative(path+".attempts", cfg.Attempts)
+	v.notNegative(path+".base", cfg.Base)
+	v.notNegative(path+".cap", cfg.Cap)
+	v.fraction(path+".jitter", cfg.Jitter)
+	for i, code := range cfg.Codes {
+		if code < 100 || code > 599 {
+			v.add(fmt.Sprintf("%v.codes[%d]", path, i), "must be http status code, got %v", code)
+		}
+	}
+}
+
+func (v *validator) breaker(path string, cfg *entity.Breaker) {
+	if cfg == nil {
+		return
+	}
+	v.fraction(path+".failurerate", cfg.FailureRate)
+	v.notNegative(path+".minrequests", cfg.MinRequests)
+	v.notNegative(path+".window", cfg.Window)
+	v.notNegative(path+".opentimeout", cfg.OpenTimeout)
+	v.notNegative(path+".probeinterval", cfg.ProbeInterval)
+	v.notNegative(path+".probes", cfg.Probes)
+}
+
+func (v *validator) adaptive(path string, cfg *entity.Adaptive) {
+	if cfg == nil {
+		return
+	}
+	v.fraction(path+".decrease", cfg.Decrease)
+	if cfg.Increase < 0 {
+		v.add(path+".increase", "must not be negative")
+	}
+	v.fraction(path+".min", cfg.Min)
+	v.notNegative(path+".latency", cfg.Latency)
+	v.notNegative(path+".interval", cfg.Interval)
+}
+
+func (v *validator) quota(path string, cfg *entity.Quota) {
+	if cfg == nil {
+		return
+	}
+	if err := limiter.NewQuota().Set(cfg); err != nil {
+		v.add(path, "%v", err)
+	}
+}
+
+// oneOf checks that value is empty (default) or one of allowed values.
+func (v *validator) oneOf(path, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(path, "must be one of %v, got %q", strings.Join(allowed, ", "), value)
+}
+
+func (v *validator) notNegative(path string, n int) {
+	if n < 0 {
+		v.add(path, "must not be negative")
+	}
+}
+
+// fraction checks that value is from 0 (default) to 1.
+func (v *validator) fraction(path string, value float64) {
+	if value < 0 || value > 1 {
+		v.add(path, "must be from 0 to 1, got %v", value)
+	}
+}
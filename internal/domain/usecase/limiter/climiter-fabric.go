@@ -8,6 +8,6 @@ func NewCLimiterFabric() *CLimiterFabric {
 	return &CLimiterFabric{}
 }
 
-func (f *CLimiterFabric) NewQPSLimiter() QPSLimiter {
-	return NewChannelLimiter()
+func (f *CLimiterFabric) NewQPSLimiter(cfg Config) (QPSLimiter, error) {
+	return NewChannelLimiter(cfg), nil
 }
@@ -4,64 +4,53 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
 )
 
 var _ QPSLimiter = (*ChannelLimiter)(nil)
 
 type ChannelLimiter struct {
-	in  chan string
-	out chan<- string
+	queue
+	*rate
 }
 
-func NewChannelLimiter() *ChannelLimiter {
-	in := make(chan string)
-	return &ChannelLimiter{in: in}
+func NewChannelLimiter(cfg Config) *ChannelLimiter {
+	r := newRate()
+	return &ChannelLimiter{queue: newQueue(cfg, r), rate: r}
 }
 
-func (l *ChannelLimiter) Init(out chan<- string) chan<- string {
-	l.out = out
-	return l.in
+func (l *ChannelLimiter) Init(out chan<- entity.Message, limit int) {
+	l.setLimit(limit)
+	l.init(out, limit)
 }
 
-func (l *ChannelLimiter) DoLimiting(ctx context.Context, limit int) {
-	buffer := make(chan string, limit)
+func (l *ChannelLimiter) DoLimiting(ctx context.Context) {
 	wg := &sync.WaitGroup{}
 	defer wg.Wait()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for s := range l.in {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-			select {
-			case <-ctx.Done():
-				return
-			case buffer <- s:
-			default:
-			}
-		}
-	}()
+	buffer := l.dispatch(ctx)
 	wg.Add(1)
 
 	// variant #1
 	go func() {
 		defer wg.Done()
-		if limit == 0 {
-			limit = 1
-		}
-		for range time.NewTicker(time.Second / time.Duration(limit)).C {
+		t := time.NewTicker(l.period())
+		defer func() { t.Stop() }()
+		for {
 			select {
 			case <-ctx.Done():
 				return
-			default:
-			}
-			select {
-			case s := <-buffer:
-				l.out <- s
-			default:
+			case <-l.retune: // new limit: restart ticker with new period, buffer stays untouched
+				t.Stop()
+				t = time.NewTicker(l.period())
+			case <-t.C:
+				select {
+				case s, ok := <-buffer:
+					if !ok || !l.send(ctx, s) {
+						return
+					}
+				default:
+				}
 			}
 		}
 	}()
@@ -70,7 +59,7 @@ func (l *ChannelLimiter) DoLimiting(ctx context.Context, limit int) {
 	/*go func() {
 		defer wg.Done()
 		localLimit := 0
-		var s string
+		var s entity.Message
 		t := time.NewTicker(time.Second)
 		for {
 			//cancel
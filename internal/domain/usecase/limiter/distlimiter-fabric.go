@@ -0,0 +1,21 @@
+package limiter
+
+var _ QPSLimiterFabric = (*DistLimiterFabric)(nil)
+
+// DistLimiterFabric creates distributed limiters sharing store, without store replica share of limit
+// is sent by ticker limiter.
+type DistLimiterFabric struct {
+	store    Store
+	replicas int
+}
+
+func NewDistLimiterFabric(store Store, replicas int) *DistLimiterFabric {
+	return &DistLimiterFabric{store: store, replicas: replicas}
+}
+
+func (f *DistLimiterFabric) NewQPSLimiter(cfg Config) (QPSLimiter, error) {
+	if f.store == nil {
+		return NewReplicaLimiter(NewChannelLimiter(cfg), f.replicas), nil
+	}
+	return NewDistributedLimiter(cfg, f.store, f.replicas), nil
+}
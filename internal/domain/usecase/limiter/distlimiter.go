@@ -0,0 +1,177 @@
+package limiter
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase"
+)
+
+// sharedWindow is rolling window of queries sent by all replicas.
+const sharedWindow = time.Second
+
+// Store is storage of logs of sent queries shared by fanouter replicas.
+type Store interface {
+	// Add removes entries of log of key older than since, adds entry with time at and returns count of entries
+	// and time of the oldest one.
+	Add(ctx context.Context, key, entry string, at, since time.Time) (count int64, oldest time.Time, err error)
+	// Remove removes entry from log of key.
+	Remove(ctx context.Context, key, entry string) error
+}
+
+var _ QPSLimiter = (*DistributedLimiter)(nil)
+
+// DistributedLimiter limits qps of feed for url across all fanouter replicas: queries are evenly spaced by limit
+// locally and each one is added to shared sliding log of the last second, query is sent if log has no more then
+// limit entries and waits for expiring of the oldest entry otherwise. While store is unavailable replica sends
+// its share of limit (limit / replicas).
+type DistributedLimiter struct {
+	queue
+	*rate
+	store    Store
+	key      string
+	replicas int
+	logger   usecase.Logger
+	failing  bool
+	id       string // prefix of entries of the limiter in shared log
+	seq      uint64
+}
+
+func NewDistributedLimiter(cfg Config, store Store, replicas int) *DistributedLimiter {
+	if replicas < 1 {
+		replicas = 1
+	}
+	r := newRate()
+	return &DistributedLimiter{queue: newQueue(cfg, r), rate: r, store: store, key: "fanouter:" + cfg.URLID + ":" + cfg.FeedID,
+		replicas: replicas, logger: cfg.Logger, id: strconv.FormatInt(rand.Int63(), 36) + "-"} //nolint:gosec
+}
+
+func (l *DistributedLimiter) Init(out chan<- entity.Message, limit int) {
+	l.setLimit(limit)
+	l.init(out, limit)
+}
+
+func (l *DistributedLimiter) DoLimiting(ctx context.Context) {
+	buffer := l.dispatch(ctx)
+
+	var next time.Time // time of the next local slot
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.retune:
+		case s, ok := <-buffer:
+			if !ok || !l.wait(ctx, &next) || !l.send(ctx, s) {
+				return
+			}
+		}
+	}
+}
+
+// wait waits for local slot and place in shared log, returns false if ctx is done.
+func (l *DistributedLimiter) wait(ctx context.Context, next *time.Time) bool {
+	for {
+		if !sleepUntil(ctx, *next) {
+			return false
+		}
+		now := time.Now()
+		wait, err := l.take(ctx, now)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return false
+			}
+			l.fail(ctx, err)
+			*next = now.Add(l.period() * time.Duration(l.replicas))
+			return true
+		case wait == 0:
+			l.recover(ctx)
+			*next = now.Add(l.period())
+			return true
+		default: // limit is reached by replicas
+			*next = now.Add(wait)
+		}
+	}
+}
+
+// take takes place in shared log, returns time to wait if log is full (entry is removed).
+func (l *DistributedLimiter) take(ctx context.Context, now time.Time) (time.Duration, error) {
+	l.seq++
+	entry := l.id + strconv.FormatUint(l.seq, 36)
+	count, oldest, err := l.store.Add(ctx, l.key, entry, now, now.Add(-sharedWindow))
+	if err != nil {
+		return 0, err
+	}
+	if count <= int64(l.getLimit()) {
+		return 0, nil
+	}
+	if err := l.store.Remove(ctx, l.key, entry); err != nil {
+		return 0, err
+	}
+	wait := oldest.Add(sharedWindow).Sub(now)
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	return wait, nil
+}
+
+func (l *DistributedLimiter) fail(ctx context.Context, err error) {
+	if !l.failing && l.logger != nil {
+		l.logger.Log(ctx, "shared limit of feed %v for url %v is unavailable, replica share is used: %v", l.feedID, l.urlID, err)
+	}
+	l.failing = true
+}
+
+func (l *DistributedLimiter) recover(ctx context.Context) {
+	if l.failing && l.logger != nil {
+		l.logger.Log(ctx, "shared limit of feed %v for url %v is available", l.feedID, l.urlID)
+	}
+	l.failing = false
+}
+
+// sleepUntil waits until t, returns false if ctx is done before.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+var _ QPSLimiter = (*ReplicaLimiter)(nil)
+
+// ReplicaLimiter is limiter of replica sending its share of limit: limit divided by count of replicas.
+type ReplicaLimiter struct {
+	QPSLimiter
+	replicas int
+}
+
+func NewReplicaLimiter(limiter QPSLimiter, replicas int) *ReplicaLimiter {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &ReplicaLimiter{QPSLimiter: limiter, replicas: replicas}
+}
+
+func (l *ReplicaLimiter) Init(out chan<- entity.Message, limit int) {
+	l.QPSLimiter.Init(out, l.share(limit))
+}
+
+func (l *ReplicaLimiter) SetLimit(limit int) {
+	l.QPSLimiter.SetLimit(l.share(limit))
+}
+
+// share returns limit of replica rounded up.
+func (l *ReplicaLimiter) share(limit int) int {
+	return (limit + l.replicas - 1) / l.replicas
+}
@@ -0,0 +1,156 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase"
+)
+
+var _ QPSLimiter = (*DurableLimiter)(nil)
+
+// DurableLimiter stores queries in journal before queueing them to limiter, query is acked when limiter sends it,
+// so accepted queries survive restart and are replayed with limiter qps. Only sequence numbers of stored queries
+// are kept in memory, query is read from journal when limiter queue has place for it.
+type DurableLimiter struct {
+	inner   QPSLimiter
+	journal Journal
+	logger  usecase.Logger
+
+	mu      sync.Mutex
+	closed  bool
+	pending []uint64 // sequence numbers of stored queries waiting for place in limiter queue
+	signal  chan struct{}
+
+	middle chan entity.Message
+	out    chan<- entity.Message
+}
+
+// NewDurableLimiter returns limiter storing queries of inner limiter, inner one must block on full queue.
+func NewDurableLimiter(inner QPSLimiter, journal Journal, logger usecase.Logger) *DurableLimiter {
+	return &DurableLimiter{inner: inner, journal: journal, logger: logger, signal: make(chan struct{}, 1)}
+}
+
+func (l *DurableLimiter) Init(out chan<- entity.Message, limit int) {
+	l.out = out
+	l.middle = make(chan entity.Message)
+	l.inner.Init(l.middle, limit)
+	l.pending = l.journal.Replay()
+}
+
+func (l *DurableLimiter) Push(ctx context.Context, msg entity.Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	seq, err := l.journal.Append(msg)
+	if err != nil {
+		return err
+	}
+	l.pending = append(l.pending, seq)
+	l.notify()
+	return nil
+}
+
+func (l *DurableLimiter) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.notify()
+}
+
+func (l *DurableLimiter) SetLimit(limit int) {
+	l.inner.SetLimit(limit)
+}
+
+func (l *DurableLimiter) DoLimiting(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	defer func() {
+		wg.Wait()
+		if err := l.journal.Close(); err != nil {
+			l.logger.Log(ctx, err)
+		}
+	}()
+	innerDone := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(innerDone)
+		l.inner.DoLimiting(ctx)
+	}()
+	wg.Add(1)
+	go func() { // acks queries sent by limiter
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-innerDone:
+				return
+			case msg := <-l.middle:
+				select {
+				case l.out <- msg:
+				case <-ctx.Done():
+					return
+				}
+				if err := l.journal.Ack(msg.Seq); err != nil {
+					l.logger.Log(ctx, err)
+				}
+			}
+		}
+	}()
+
+	// stored queries are moved to limiter queue when it has place
+	for {
+		seq, ok, closed := l.next()
+		if closed {
+			l.inner.Close()
+			return
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.signal:
+			}
+			continue
+		}
+		msg, err := l.journal.Read(seq)
+		if err != nil {
+			l.logger.Log(ctx, err)
+			continue
+		}
+		msg.Seq = seq
+		for {
+			err := l.inner.Push(ctx, msg)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// next returns sequence number of the oldest pending query, closed is true if limiter is closed and has no pending
+// queries.
+func (l *DurableLimiter) next() (seq uint64, ok, closed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) == 0 {
+		return 0, false, l.closed
+	}
+	seq = l.pending[0]
+	l.pending = l.pending[1:]
+	return seq, true, false
+}
+
+func (l *DurableLimiter) notify() {
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,162 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+// FairQueue shares capacity of sender of url and its gate among limiters of its feeds by weights (start-time fair
+// queuing): while sender is busy or gate is closed, queries sent by limiters wait and the next one is taken from flow
+// with the least count of sent queries divided by weight. So flow with weight 2 gets twice as many queries sent
+// as flow with weight 1 while both have queries, idle flow does not save its share for later.
+type FairQueue struct {
+	mu     sync.Mutex
+	out    chan<- entity.Message
+	gate   Barrier // passed by each query before it is taken from flow, nil if there is no gate
+	flows  map[*Flow]struct{}
+	vtime  float64       // virtual time of the last sent query
+	ready  chan struct{} // signaled when flow has new head query, is removed or queue is closed
+	closed bool
+	done   chan struct{} // closed when queue stopped
+}
+
+// Flow is input of fair queue written by limiter of feed.
+type Flow struct {
+	in     chan entity.Message
+	taken  chan struct{} // signaled when head query is taken to be sent
+	weight float64
+	pass   float64 // virtual time of head query
+	head   *entity.Message
+}
+
+func NewFairQueue(out chan<- entity.Message, gate Barrier) *FairQueue {
+	return &FairQueue{out: out, gate: gate, flows: make(map[*Flow]struct{}), ready: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// In returns channel for queries of flow, it must be passed to limiter as output.
+func (fl *Flow) In() chan<- entity.Message {
+	return fl.in
+}
+
+// Add adds flow with weight (1 if not positive).
+func (q *FairQueue) Add(weight int) *Flow {
+	fl := &Flow{in: make(chan entity.Message), taken: make(chan struct{}, 1), weight: flowWeight(weight)}
+	q.mu.Lock()
+	q.flows[fl] = struct{}{}
+	q.mu.Unlock()
+	go q.receive(fl)
+	return fl
+}
+
+// SetWeight changes weight of flow (1 if not positive).
+func (q *FairQueue) SetWeight(fl *Flow, weight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fl.weight = flowWeight(weight)
+}
+
+// Remove removes flow whose limiter stopped, query received from it before is still sent.
+func (q *FairQueue) Remove(fl *Flow) {
+	close(fl.in)
+}
+
+// Close stops queue when all flows are removed and their queries are sent.
+func (q *FairQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Run sends queries of flows to out until queue is closed and drained or ctx is done.
+func (q *FairQueue) Run(ctx context.Context) {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		fl := q.next()
+		if fl == nil {
+			stop := q.closed && len(q.flows) == 0
+			q.mu.Unlock()
+			if stop {
+				return
+			}
+			select {
+			case <-q.ready:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if q.gate != nil { // flow is picked when gate is passed, so queries of all flows compete for the slot
+			q.mu.Unlock()
+			if q.gate.Wait(ctx) != nil {
+				return
+			}
+			q.mu.Lock()
+			fl = q.next() // head query is taken only here, so flow still has one
+		}
+		msg := *fl.head
+		fl.head = nil
+		q.vtime = fl.pass
+		fl.pass += 1 / fl.weight
+		q.mu.Unlock()
+		fl.taken <- struct{}{} // flow receives its next query while this one waits for sender
+		select {
+		case q.out <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// next returns flow with head query of the least virtual time, nil if no flow has query. Must be called under lock.
+func (q *FairQueue) next() *Flow {
+	var next *Flow
+	for fl := range q.flows {
+		if fl.head != nil && (next == nil || fl.pass < next.pass) {
+			next = fl
+		}
+	}
+	return next
+}
+
+// receive makes queries of flow its head one by one until flow is removed.
+func (q *FairQueue) receive(fl *Flow) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.flows, fl)
+		q.mu.Unlock()
+		q.signal()
+	}()
+	for msg := range fl.in {
+		msg := msg
+		q.mu.Lock()
+		fl.head = &msg
+		if fl.pass < q.vtime { // flow was idle
+			fl.pass = q.vtime
+		}
+		q.mu.Unlock()
+		q.signal()
+		select {
+		case <-fl.taken:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *FairQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func flowWeight(weight int) float64 {
+	if weight <= 0 {
+		return 1
+	}
+	return float64(weight)
+}
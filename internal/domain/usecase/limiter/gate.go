@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Barrier is restriction shared by several limiters which queries pass before sending: qps gate or quota.
+type Barrier interface {
+	// Wait waits until query can be sent.
+	Wait(ctx context.Context) error
+}
+
+var _ Barrier = (*Gate)(nil)
+
+// Gate is qps limit shared by several limiters: of url across its feeds, of feed across its urls or of the process.
+// Queries sent by limiters pass gate evenly spaced, gate without limit lets all queries through.
+type Gate struct {
+	mu    sync.Mutex
+	limit int
+	next  time.Time // time of the next free slot
+}
+
+func NewGate(limit int) *Gate {
+	return &Gate{limit: limit}
+}
+
+// SetLimit changes limit of gate, 0 removes the limit.
+func (g *Gate) SetLimit(limit int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit = limit
+}
+
+// Wait reserves the next free slot of gate and waits for it.
+func (g *Gate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	if g.limit <= 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	slot := g.next
+	if slot.Before(now) {
+		slot = now
+	}
+	g.next = slot.Add(time.Second / time.Duration(g.limit))
+	g.mu.Unlock()
+
+	wait := slot.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,13 @@
+package limiter
+
+var _ QPSLimiterFabric = (*GCRALimiterFabric)(nil)
+
+type GCRALimiterFabric struct{}
+
+func NewGCRALimiterFabric() *GCRALimiterFabric {
+	return &GCRALimiterFabric{}
+}
+
+func (f *GCRALimiterFabric) NewQPSLimiter(cfg Config) (QPSLimiter, error) {
+	return NewGCRALimiter(cfg), nil
+}
@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+var _ QPSLimiter = (*GCRALimiter)(nil)
+
+// GCRALimiter is generic cell rate algorithm: query is sent if it arrives not earlier than theoretical arrival
+// time minus burst tolerance. With burst 1 queries are evenly spaced and no rolling window exceeds limit.
+type GCRALimiter struct {
+	queue
+	*rate
+	burst int
+}
+
+func NewGCRALimiter(cfg Config) *GCRALimiter {
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	r := newRate()
+	return &GCRALimiter{queue: newQueue(cfg, r), rate: r, burst: burst}
+}
+
+func (l *GCRALimiter) Init(out chan<- entity.Message, limit int) {
+	l.setLimit(limit)
+	size := limit
+	if size < l.burst {
+		size = l.burst
+	}
+	l.init(out, size)
+}
+
+func (l *GCRALimiter) DoLimiting(ctx context.Context) {
+	buffer := l.dispatch(ctx)
+
+	var tat time.Time // theoretical arrival time
+	for {
+		now := time.Now()
+		period := l.period()
+		in := buffer
+		var (
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+		if allowed := tat.Add(-time.Duration(l.burst-1) * period); now.Before(allowed) {
+			in = nil
+			timer = time.NewTimer(allowed.Sub(now))
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.retune:
+		case <-wait:
+		case s, ok := <-in:
+			if !ok || !l.send(ctx, s) {
+				return
+			}
+			if now = time.Now(); tat.Before(now) {
+				tat = now
+			}
+			tat = tat.Add(period)
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package limiter
+
+import "github.com/shipa988/fanouter/internal/domain/entity"
+
+// Journal is durable log of queries queued by limiter of feed for url.
+type Journal interface {
+	// Append stores message and returns its sequence number.
+	Append(msg entity.Message) (uint64, error)
+	// Read returns stored message which is not acked.
+	Read(seq uint64) (entity.Message, error)
+	// Ack marks message as sent.
+	Ack(seq uint64) error
+	// Replay returns sequence numbers of messages not sent before previous stop, they are returned only once.
+	Replay() []uint64
+	Close() error
+}
+
+// JournalFabric opens journal of limiter of feed for url.
+type JournalFabric interface {
+	OpenJournal(urlID, feedID string) (Journal, error)
+}
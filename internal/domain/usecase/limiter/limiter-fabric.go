@@ -1,5 +1,139 @@
 package limiter
 
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/usecase"
+)
+
+const (
+	AlgorithmTicker      = "ticker"
+	AlgorithmTokenBucket = "token_bucket"
+	AlgorithmSlidingLog  = "sliding_window_log"
+	AlgorithmGCRA        = "gcra"
+	AlgorithmDistributed = "distributed"
+)
+
+const (
+	ErrAlgorithm = "unknown limiting algorithm %q"
+	ErrJournal   = "can't open durable queue of feed %v for url %v"
+	ErrDurable   = "durable queues are not configured"
+)
+
+// durableBlockTimeout is timeout of waiting for place in limiter queue for query stored in durable queue.
+const durableBlockTimeout = time.Second
+
+// Config is parameters of limiter of feed for url.
+type Config struct {
+	URLID     string
+	FeedID    string
+	Algorithm string
+	Burst     int
+	Overflow  Overflow
+	Durable   bool
+	// Starvation is max count of queries of higher priority classes sent while query of lower class waits.
+	Starvation int
+	Gates      []Barrier // shared limits passed by queries sent by limiter
+	Metrics    usecase.Metrics
+	Logger     usecase.Logger
+}
+
 type QPSLimiterFabric interface {
-	NewQPSLimiter() QPSLimiter
+	NewQPSLimiter(cfg Config) (QPSLimiter, error)
+}
+
+// ConfigChecker is QPSLimiterFabric which can check whether it creates limiter of config before it is needed.
+type ConfigChecker interface {
+	// CheckAlgorithm returns error if limiter of algorithm can't be created, empty algorithm is default one.
+	CheckAlgorithm(algorithm string) error
+	// CheckDurable returns error if durable limiters can't be created.
+	CheckDurable() error
+}
+
+var _ QPSLimiterFabric = (*Fabrics)(nil)
+var _ ConfigChecker = (*Fabrics)(nil)
+
+// Fabrics creates limiters by fabric registered for algorithm of config, default fabric is used for empty algorithm.
+type Fabrics struct {
+	def      QPSLimiterFabric
+	fabrics  map[string]QPSLimiterFabric
+	journals JournalFabric
+}
+
+func NewFabrics(def QPSLimiterFabric) *Fabrics {
+	return &Fabrics{def: def, fabrics: make(map[string]QPSLimiterFabric)}
+}
+
+// NewDefaultFabrics returns Fabrics of all limiting algorithms with ticker limiter by default.
+func NewDefaultFabrics() *Fabrics {
+	return NewFabrics(NewCLimiterFabric()).
+		Register(AlgorithmTicker, NewCLimiterFabric()).
+		Register(AlgorithmTokenBucket, NewTBLimiterFabric()).
+		Register(AlgorithmSlidingLog, NewSWLimiterFabric()).
+		Register(AlgorithmGCRA, NewGCRALimiterFabric())
+}
+
+func (f *Fabrics) Register(algorithm string, fabric QPSLimiterFabric) *Fabrics {
+	f.fabrics[algorithm] = fabric
+	return f
+}
+
+// WithJournals sets fabric of journals for durable limiters, without it durable limiters can't be created.
+func (f *Fabrics) WithJournals(journals JournalFabric) *Fabrics {
+	f.journals = journals
+	return f
+}
+
+func (f *Fabrics) CheckAlgorithm(algorithm string) error {
+	if _, ok := f.fabrics[algorithm]; !ok && algorithm != "" {
+		return errors.Errorf(ErrAlgorithm, algorithm)
+	}
+	return nil
+}
+
+func (f *Fabrics) CheckDurable() error {
+	if f.journals == nil {
+		return errors.New(ErrDurable)
+	}
+	return nil
+}
+
+func (f *Fabrics) NewQPSLimiter(cfg Config) (QPSLimiter, error) {
+	if cfg.Durable {
+		return f.newDurable(cfg)
+	}
+	if err := cfg.Overflow.Validate(); err != nil {
+		return nil, err
+	}
+	return f.newLimiter(cfg)
+}
+
+// newDurable creates limiter of algorithm of config behind durable queue, stored queries wait for place in its queue.
+func (f *Fabrics) newDurable(cfg Config) (QPSLimiter, error) {
+	if f.journals == nil {
+		return nil, errors.Errorf(ErrJournal, cfg.FeedID, cfg.URLID)
+	}
+	cfg.Overflow = Overflow{Policy: OverflowBlock, Timeout: durableBlockTimeout}
+	inner, err := f.newLimiter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	journal, err := f.journals.OpenJournal(cfg.URLID, cfg.FeedID)
+	if err != nil {
+		return nil, errors.Wrapf(err, ErrJournal, cfg.FeedID, cfg.URLID)
+	}
+	return NewDurableLimiter(inner, journal, cfg.Logger), nil
+}
+
+func (f *Fabrics) newLimiter(cfg Config) (QPSLimiter, error) {
+	if cfg.Algorithm == "" {
+		return f.def.NewQPSLimiter(cfg)
+	}
+	fabric, ok := f.fabrics[cfg.Algorithm]
+	if !ok {
+		return nil, errors.Errorf(ErrAlgorithm, cfg.Algorithm)
+	}
+	return fabric.NewQPSLimiter(cfg)
 }
@@ -1,9 +1,19 @@
 package limiter
 
-import "context"
+import (
+	"context"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
 
 // QPSLimiter is abstract qps limiter (for using different algorithms of limiting).
 type QPSLimiter interface {
-	Init(out chan<- string) chan<- string
-	DoLimiting(ctx context.Context, limit int)
+	Init(out chan<- entity.Message, limit int)
+	// Push queues query for sending, overflow policy of limiter is applied when queue is full.
+	Push(ctx context.Context, msg entity.Message) error
+	// Close stops receiving queries, DoLimiting returns after sending queued ones.
+	Close()
+	DoLimiting(ctx context.Context)
+	// SetLimit retunes the rate of running limiter in place, queued items are kept.
+	SetLimit(limit int)
 }
@@ -0,0 +1,437 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase"
+)
+
+const (
+	OverflowDropNewest = "drop_newest"
+	OverflowDropOldest = "drop_oldest"
+	OverflowBlock      = "block"
+	OverflowReject     = "reject"
+)
+
+const (
+	ErrOverflowPolicy = "unknown overflow policy %q"
+)
+
+var (
+	// ErrClosed is returned by Push of closed limiter.
+	ErrClosed = errors.New("limiter is closed")
+	// ErrDropped is returned by Push when query is dropped because of full queue.
+	ErrDropped = errors.New("queue is full, query dropped")
+	// errFull is returned by offer when query should wait for free place in queue by block policy.
+	errFull = errors.New("queue is full")
+)
+
+// OverflowError is returned by Push of limiter with reject overflow policy when its queue is full.
+type OverflowError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OverflowError) Error() string {
+	return "queue is full, retry after " + e.RetryAfter.String()
+}
+
+// Overflow is policy applied when queue of limiter is full.
+type Overflow struct {
+	Policy  string        // drop newest query if empty
+	Timeout time.Duration // max time of waiting for free place in queue for block policy
+}
+
+// Validate checks that policy is known.
+func (o Overflow) Validate() error {
+	switch o.Policy {
+	case "", OverflowDropNewest, OverflowDropOldest, OverflowBlock, OverflowReject:
+		return nil
+	default:
+		return errors.Errorf(ErrOverflowPolicy, o.Policy)
+	}
+}
+
+// Levels of priority classes of queued queries.
+const (
+	levelHigh = iota
+	levelNormal
+	levelLow
+	levels
+)
+
+// defaultStarvation is max count of queries of higher classes sent while query of lower class waits.
+const defaultStarvation = 10
+
+// queue is input of limiter: incoming queries are buffered by priority classes until limiting algorithm sends
+// them to out. Every class has its own buffer of queue size, overflow policy is applied to buffer of query class.
+// Queue grows when limit is raised above its size by SetLimit, so queries of the new rate fit in it.
+// Queries blocked by full buffer wait for free place without lock, so they don't stall growing and closing.
+type queue struct {
+	mu         sync.RWMutex
+	closed     bool
+	buffers    [levels]chan entity.Message
+	size       int32         // capacity of each buffer
+	resized    chan struct{} // signaled when buffers are replaced by larger ones
+	freeMu     sync.Mutex
+	free       chan struct{} // closed when place in buffers may be freed, nil if nobody waits for it
+	out        chan<- entity.Message
+	overflow   Overflow
+	rate       *rate
+	urlID      string
+	feedID     string
+	metrics    usecase.Metrics
+	gates      []Barrier
+	starvation int
+}
+
+func newQueue(cfg Config, rate *rate) queue {
+	starvation := cfg.Starvation
+	if starvation <= 0 {
+		starvation = defaultStarvation
+	}
+	return queue{overflow: cfg.Overflow, rate: rate, urlID: cfg.URLID, feedID: cfg.FeedID, metrics: cfg.Metrics, gates: cfg.Gates,
+		starvation: starvation, resized: make(chan struct{}, 1)}
+}
+
+func (q *queue) init(out chan<- entity.Message, size int) {
+	q.out = out
+	q.size = int32(size)
+	for i := range q.buffers {
+		q.buffers[i] = make(chan entity.Message, size)
+	}
+}
+
+// grow replaces buffers by ones of current limit if it is larger than their size, queued queries are moved in order.
+func (q *queue) grow() {
+	size := int32(q.rate.getLimit())
+	if size <= atomic.LoadInt32(&q.size) {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || size <= q.size {
+		return
+	}
+	for i, old := range q.buffers {
+		buffer := make(chan entity.Message, size)
+	move:
+		for {
+			select {
+			case msg := <-old:
+				buffer <- msg
+			default:
+				break move
+			}
+		}
+		q.buffers[i] = buffer
+	}
+	atomic.StoreInt32(&q.size, size)
+	select {
+	case q.resized <- struct{}{}:
+	default:
+	}
+	q.signalFree()
+}
+
+// freed returns channel closed when place in buffers may be freed: query is dispatched, buffers grow or queue
+// is closed.
+func (q *queue) freed() <-chan struct{} {
+	q.freeMu.Lock()
+	defer q.freeMu.Unlock()
+	if q.free == nil {
+		q.free = make(chan struct{})
+	}
+	return q.free
+}
+
+// signalFree wakes up queries waiting for free place in buffers.
+func (q *queue) signalFree() {
+	q.freeMu.Lock()
+	defer q.freeMu.Unlock()
+	if q.free != nil {
+		close(q.free)
+		q.free = nil
+	}
+}
+
+// current returns buffers of queue.
+func (q *queue) current() [levels]chan entity.Message {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.buffers
+}
+
+// level returns level of priority class of query.
+func level(msg entity.Message) int {
+	switch msg.Priority {
+	case entity.PriorityHigh:
+		return levelHigh
+	case entity.PriorityLow:
+		return levelLow
+	default:
+		return levelNormal
+	}
+}
+
+// depth returns count of queued queries of all classes.
+func (q *queue) depth() int {
+	return depth(q.current())
+}
+
+// depth returns count of queries in buffers.
+func depth(buffers [levels]chan entity.Message) int {
+	n := 0
+	for _, buffer := range buffers {
+		n += len(buffer)
+	}
+	return n
+}
+
+func (q *queue) Push(ctx context.Context, msg entity.Message) error {
+	q.grow()
+	err := q.push(ctx, msg)
+	if err == ErrClosed {
+		return err
+	}
+	if err == nil {
+		q.metrics.Enqueued(q.urlID, q.feedID)
+	} else {
+		q.metrics.Dropped(q.urlID, q.feedID)
+	}
+	q.metrics.QueueDepth(q.urlID, q.feedID, q.depth())
+	return err
+}
+
+// push offers query to queue until it is accepted or rejected, query blocked by full buffer waits for free place
+// up to timeout of block policy.
+func (q *queue) push(ctx context.Context, msg entity.Message) error {
+	var timeout <-chan time.Time
+	for {
+		free := q.freed() // taken before offer, so place freed after failed offer is not missed
+		err := q.offer(msg)
+		if err != errFull {
+			return err
+		}
+		if timeout == nil {
+			timer := time.NewTimer(q.overflow.Timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-free:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return ErrDropped
+		}
+	}
+}
+
+// offer puts query to buffer of its class applying overflow policy, errFull is returned instead of waiting
+// for block policy.
+func (q *queue) offer(msg entity.Message) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	buffer := q.buffers[level(msg)]
+	select {
+	case buffer <- msg:
+		return nil
+	default:
+	}
+	switch q.overflow.Policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-buffer:
+				q.metrics.Dropped(q.urlID, q.feedID)
+			default:
+			}
+			select {
+			case buffer <- msg:
+				return nil
+			default:
+			}
+		}
+	case OverflowBlock:
+		return errFull
+	case OverflowReject:
+		return &OverflowError{RetryAfter: time.Duration(depth(q.buffers)) * q.rate.period()}
+	default:
+		return ErrDropped
+	}
+}
+
+// Close stops receiving queries, queued items are still sent by limiter.
+func (q *queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		for _, buffer := range q.buffers {
+			close(buffer)
+		}
+		q.signalFree()
+	}
+}
+
+// dispatch starts moving queued queries to returned channel read by limiting algorithm until ctx is done:
+// query of the highest class is sent first, but waiting query of lower class is sent after starvation queries
+// of higher classes. Channel is closed when queue is closed and all queued queries are sent.
+func (q *queue) dispatch(ctx context.Context) <-chan entity.Message {
+	out := make(chan entity.Message)
+	go func() {
+		defer close(out)
+		d := dispatcher{starvation: q.starvation, resized: q.resized, current: q.current, buffers: q.current()}
+		for {
+			msg, ok := d.pop(ctx)
+			if !ok {
+				return
+			}
+			q.signalFree()
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// dispatcher is state of dispatching of queued queries.
+type dispatcher struct {
+	starvation int
+	resized    <-chan struct{}
+	current    func() [levels]chan entity.Message // returns buffers of queue
+	buffers    [levels]chan entity.Message        // nil for closed and drained buffers
+	skips      [levels]int                        // queries of higher classes sent while the class waits
+}
+
+// pop takes the next query to send, returns false if ctx is done or all buffers are closed and drained.
+func (d *dispatcher) pop(ctx context.Context) (entity.Message, bool) {
+	for {
+		if msg, ok := d.take(); ok {
+			return msg, true
+		}
+		if d.buffers[levelHigh] == nil && d.buffers[levelNormal] == nil && d.buffers[levelLow] == nil {
+			return entity.Message{}, false
+		}
+		var (
+			msg entity.Message
+			ok  bool
+			lvl int
+		)
+		select { // all buffers are empty: wait for any query
+		case <-ctx.Done():
+			return entity.Message{}, false
+		case msg, ok = <-d.buffers[levelHigh]:
+			lvl = levelHigh
+		case msg, ok = <-d.buffers[levelNormal]:
+			lvl = levelNormal
+		case msg, ok = <-d.buffers[levelLow]:
+			lvl = levelLow
+		case <-d.resized: // queued queries are moved to new buffers
+			d.buffers = d.current()
+			continue
+		}
+		if ok {
+			return msg, true
+		}
+		d.buffers[lvl] = nil
+	}
+}
+
+// take takes queued query of starving class or of the highest class without waiting.
+func (d *dispatcher) take() (entity.Message, bool) {
+	order := make([]int, 0, 2*levels)
+	for lvl := levelLow; lvl > levelHigh; lvl-- { // starving classes first, the lowest one first
+		if d.skips[lvl] >= d.starvation {
+			order = append(order, lvl)
+		}
+	}
+	for lvl := levelHigh; lvl < levels; lvl++ {
+		order = append(order, lvl)
+	}
+	for _, lvl := range order {
+		if d.buffers[lvl] == nil {
+			continue
+		}
+		select {
+		case msg, ok := <-d.buffers[lvl]:
+			if !ok {
+				d.buffers[lvl] = nil
+				continue
+			}
+			d.skips[lvl] = 0
+			for lower := lvl + 1; lower < levels; lower++ {
+				if d.buffers[lower] != nil && len(d.buffers[lower]) > 0 {
+					d.skips[lower]++
+				}
+			}
+			return msg, true
+		default:
+		}
+	}
+	return entity.Message{}, false
+}
+
+// send sends query to out after passing shared gates and quotas, returns false if ctx is done before sending.
+func (q *queue) send(ctx context.Context, msg entity.Message) bool {
+	q.metrics.QueueDepth(q.urlID, q.feedID, q.depth())
+	for _, gate := range q.gates {
+		if gate.Wait(ctx) != nil {
+			return false
+		}
+	}
+	select {
+	case q.out <- msg:
+		q.metrics.Sent(q.urlID, q.feedID)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// rate is limit of running limiter which can be changed in place.
+type rate struct {
+	limit  int32
+	retune chan struct{}
+}
+
+func newRate() *rate {
+	return &rate{retune: make(chan struct{}, 1)}
+}
+
+func (r *rate) SetLimit(limit int) {
+	atomic.StoreInt32(&r.limit, int32(limit))
+	select {
+	case r.retune <- struct{}{}:
+	default:
+	}
+}
+
+func (r *rate) setLimit(limit int) {
+	atomic.StoreInt32(&r.limit, int32(limit))
+}
+
+func (r *rate) getLimit() int {
+	limit := int(atomic.LoadInt32(&r.limit))
+	if limit <= 0 {
+		limit = 1
+	}
+	return limit
+}
+
+// period returns interval between two outgoing queries for current limit.
+func (r *rate) period() time.Duration {
+	return time.Second / time.Duration(r.getLimit())
+}
@@ -0,0 +1,210 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	ErrQuotaPeriod   = "unknown quota period %q"
+	ErrQuotaPolicy   = "unknown quota exhausted policy %q"
+	ErrQuotaTimezone = "unknown quota timezone %v"
+)
+
+var _ Barrier = (*Quota)(nil)
+
+// QuotaError is returned by fanout of query to url whose quota with reject policy is exhausted.
+type QuotaError struct {
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return "quota is exhausted, retry after " + e.RetryAfter.Round(time.Second).String()
+}
+
+// QuotaStatus is usage of quota in current period.
+type QuotaStatus struct {
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	Period    string    `json:"period"`
+	Exhausted string    `json:"exhausted"` // policy applied when quota is exhausted
+	Reset     time.Time `json:"reset"`     // start of the next period
+}
+
+// Quota is budget of queries sent per day or month shared by limiters. Query is taken from quota with defer policy
+// when it is sent and waits for the next period if quota is exhausted; query is taken from quota with reject policy
+// when it is accepted by Take, so accepted queries are sent without waiting. Quota without limit lets all queries
+// through.
+type Quota struct {
+	mu        sync.Mutex
+	limit     int64
+	period    string
+	exhausted string
+	loc       *time.Location
+	start     time.Time // start of current period
+	used      int64
+	changed   chan struct{} // closed when settings are changed: waiting queries check quota again
+}
+
+func NewQuota() *Quota {
+	return &Quota{loc: time.UTC, changed: make(chan struct{})}
+}
+
+// Set changes settings of quota, count of used queries is kept while start of current period is the same.
+// Nil settings remove limit.
+func (q *Quota) Set(cfg *entity.Quota) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.notify()
+	if cfg == nil || cfg.Limit <= 0 {
+		q.limit = 0
+		return nil
+	}
+	switch cfg.Period {
+	case entity.QuotaDay, entity.QuotaMonth:
+	default:
+		q.limit = 0
+		return errors.Errorf(ErrQuotaPeriod, cfg.Period)
+	}
+	exhausted := cfg.Exhausted
+	switch exhausted {
+	case "":
+		exhausted = entity.QuotaReject
+	case entity.QuotaReject, entity.QuotaDefer:
+	default:
+		q.limit = 0
+		return errors.Errorf(ErrQuotaPolicy, cfg.Exhausted)
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		q.limit = 0
+		return errors.Wrapf(err, ErrQuotaTimezone, cfg.Timezone)
+	}
+	q.limit, q.period, q.exhausted, q.loc = cfg.Limit, cfg.Period, exhausted, loc
+	q.roll(time.Now())
+	return nil
+}
+
+// Wait takes one query from quota with defer policy, waits for the next period if quota is exhausted.
+// Query of quota with reject policy is taken by Take before, so it is not waited.
+func (q *Quota) Wait(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		if q.limit <= 0 || q.exhausted == entity.QuotaReject {
+			q.mu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		q.roll(now)
+		if q.used < q.limit {
+			q.used++
+			q.mu.Unlock()
+			return nil
+		}
+		timer := time.NewTimer(q.end().Sub(now))
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Take takes one query from quota with reject policy, returns false and time until start of the next period
+// if quota is exhausted. Quota with defer policy or without limit accepts all queries, they are taken by Wait.
+func (q *Quota) Take(now time.Time) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit <= 0 || q.exhausted != entity.QuotaReject {
+		return 0, true
+	}
+	q.roll(now)
+	if q.used >= q.limit {
+		return q.end().Sub(now), false
+	}
+	q.used++
+	return 0, true
+}
+
+// Refund returns query taken by Take which is not queued.
+func (q *Quota) Refund() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit > 0 && q.exhausted == entity.QuotaReject && q.used > 0 {
+		q.used--
+	}
+}
+
+// Status returns usage of quota, ok is false if quota has no limit.
+func (q *Quota) Status(now time.Time) (status QuotaStatus, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit <= 0 {
+		return QuotaStatus{}, false
+	}
+	q.roll(now)
+	remaining := q.limit - q.used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaStatus{Limit: q.limit, Used: q.used, Remaining: remaining, Period: q.period, Exhausted: q.exhausted,
+		Reset: q.end()}, true
+}
+
+// Usage returns start of current period and count of used queries.
+func (q *Quota) Usage() (time.Time, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.start, q.used
+}
+
+// Restore restores count of queries used in period started at start (saved before restart).
+// It is ignored if the period is over.
+func (q *Quota) Restore(start time.Time, used int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit <= 0 {
+		return
+	}
+	q.roll(time.Now())
+	if start.Equal(q.start) && used > q.used {
+		q.used = used
+	}
+}
+
+// roll starts the next period if current one is over. Must be called under lock.
+func (q *Quota) roll(now time.Time) {
+	t := now.In(q.loc)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, q.loc)
+	if q.period == entity.QuotaMonth {
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, q.loc)
+	}
+	if !start.Equal(q.start) {
+		q.start, q.used = start, 0
+	}
+}
+
+// end returns start of the next period. Must be called under lock.
+func (q *Quota) end() time.Time {
+	if q.period == entity.QuotaMonth {
+		return q.start.AddDate(0, 1, 0)
+	}
+	return q.start.AddDate(0, 0, 1)
+}
+
+// notify wakes queries waiting for the next period. Must be called under lock.
+func (q *Quota) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,13 @@
+package limiter
+
+var _ QPSLimiterFabric = (*SWLimiterFabric)(nil)
+
+type SWLimiterFabric struct{}
+
+func NewSWLimiterFabric() *SWLimiterFabric {
+	return &SWLimiterFabric{}
+}
+
+func (f *SWLimiterFabric) NewQPSLimiter(cfg Config) (QPSLimiter, error) {
+	return NewSlidingWindowLimiter(cfg), nil
+}
@@ -0,0 +1,66 @@
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+// window is rolling window of SlidingWindowLimiter.
+const window = time.Second
+
+var _ QPSLimiter = (*SlidingWindowLimiter)(nil)
+
+// SlidingWindowLimiter keeps log of sending times and sends query only if less than limit queries
+// were sent during last rolling second, so no rolling window ever exceeds limit.
+type SlidingWindowLimiter struct {
+	queue
+	*rate
+}
+
+func NewSlidingWindowLimiter(cfg Config) *SlidingWindowLimiter {
+	r := newRate()
+	return &SlidingWindowLimiter{queue: newQueue(cfg, r), rate: r}
+}
+
+func (l *SlidingWindowLimiter) Init(out chan<- entity.Message, limit int) {
+	l.setLimit(limit)
+	l.init(out, limit)
+}
+
+func (l *SlidingWindowLimiter) DoLimiting(ctx context.Context) {
+	buffer := l.dispatch(ctx)
+
+	var sent []time.Time
+	for {
+		now := time.Now()
+		for len(sent) > 0 && now.Sub(sent[0]) >= window {
+			sent = sent[1:]
+		}
+		in := buffer
+		var (
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+		if limit := l.getLimit(); len(sent) >= limit { // window is full: wait until enough queries leave it
+			in = nil
+			timer = time.NewTimer(sent[len(sent)-limit].Add(window).Sub(now))
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.retune:
+		case <-wait:
+		case s, ok := <-in:
+			if !ok || !l.send(ctx, s) {
+				return
+			}
+			sent = append(sent, time.Now())
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package limiter
+
+var _ QPSLimiterFabric = (*TBLimiterFabric)(nil)
+
+type TBLimiterFabric struct{}
+
+func NewTBLimiterFabric() *TBLimiterFabric {
+	return &TBLimiterFabric{}
+}
+
+func (f *TBLimiterFabric) NewQPSLimiter(cfg Config) (QPSLimiter, error) {
+	return NewTokenBucketLimiter(cfg), nil
+}
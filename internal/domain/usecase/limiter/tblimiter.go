@@ -0,0 +1,66 @@
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+var _ QPSLimiter = (*TokenBucketLimiter)(nil)
+
+// TokenBucketLimiter sends queries while bucket has tokens. Bucket is refilled with limit tokens per second
+// up to burst, so short bursts are allowed but long-run rate matches limit.
+type TokenBucketLimiter struct {
+	queue
+	*rate
+	burst int
+}
+
+func NewTokenBucketLimiter(cfg Config) *TokenBucketLimiter {
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	r := newRate()
+	return &TokenBucketLimiter{queue: newQueue(cfg, r), rate: r, burst: burst}
+}
+
+func (l *TokenBucketLimiter) Init(out chan<- entity.Message, limit int) {
+	l.setLimit(limit)
+	size := limit
+	if size < l.burst {
+		size = l.burst
+	}
+	l.init(out, size)
+}
+
+func (l *TokenBucketLimiter) DoLimiting(ctx context.Context) {
+	buffer := l.dispatch(ctx)
+
+	tokens := l.burst
+	t := time.NewTicker(l.period())
+	defer func() { t.Stop() }()
+	for {
+		in := buffer
+		if tokens == 0 { // empty bucket: wait for refill
+			in = nil
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.retune:
+			t.Stop()
+			t = time.NewTicker(l.period())
+		case <-t.C:
+			if tokens < l.burst {
+				tokens++
+			}
+		case s, ok := <-in:
+			if !ok || !l.send(ctx, s) {
+				return
+			}
+			tokens--
+		}
+	}
+}
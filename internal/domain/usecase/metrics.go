@@ -0,0 +1,34 @@
+package usecase
+
+import "time"
+
+// Metrics is abstract collector of fanout, limiters and senders metrics.
+type Metrics interface {
+	// Fanout counts incoming fanout query of feed.
+	Fanout(feedID string)
+	// Enqueued counts query queued by limiter of feed for url.
+	Enqueued(urlID, feedID string)
+	// Dropped counts query dropped or rejected by limiter of feed for url because of full queue.
+	Dropped(urlID, feedID string)
+	// QueueDepth sets current count of queued queries of limiter.
+	QueueDepth(urlID, feedID string, depth int)
+	// Limit sets configured qps of limiter: limit of active profile of feed before adaptive rate of url is applied.
+	Limit(urlID, feedID string, limit int)
+	// Sent counts query sent by limiter to sender (achieved qps).
+	Sent(urlID, feedID string)
+	// Retry counts failed request to url queued again for retry.
+	Retry(urlID, feedID string)
+	// ShortCircuit counts request to url short-circuited by open breaker.
+	ShortCircuit(urlID, feedID string)
+	// DeadLetter counts query which could not be delivered to url and was stored as dead letter.
+	DeadLetter(urlID, feedID string)
+	// QuotaRemaining sets remaining quota of url (feedID is empty) or of feed for url in current period.
+	QuotaRemaining(urlID, feedID string, remaining int64)
+	// BreakerState sets current state of url breaker.
+	BreakerState(urlID, state string)
+	// Request observes outgoing request to url, code is 0 if request failed without response.
+	Request(urlID, feedID string, code int, latency time.Duration)
+	// Remove deletes metrics of feed for url removed from parameters, metrics of url itself if feedID is empty
+	// and metrics of feed itself if urlID is empty.
+	Remove(urlID, feedID string)
+}
@@ -0,0 +1,174 @@
+package sender
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	defaultDecrease      = 0.5
+	defaultIncrease      = 0.1
+	defaultMinRate       = 0.1
+	defaultAdaptInterval = time.Second
+)
+
+// AdaptiveStatus is current rate of url.
+type AdaptiveStatus struct {
+	Enabled bool      `json:"enabled"`
+	Rate    float64   `json:"rate"`           // multiplier of configured limits
+	P99     float64   `json:"p99"`            // p99 latency of the last interval in milliseconds
+	Hold    time.Time `json:"hold,omitempty"` // rate is not increased until the time asked by Retry-After
+	Since   time.Time `json:"since"`          // time of last rate change
+}
+
+// Adaptive is AIMD controller of rate of url: rate is multiplied by decrease on throttling response
+// (429, 503 or Retry-After header) or p99 latency of interval above threshold, but not often then once per interval,
+// so responses to queries sent at previous rate don't decrease it again. Rate is increased by increase after
+// interval without overload up to 1.
+type Adaptive struct {
+	enabled  bool
+	decrease float64
+	increase float64
+	min      float64
+	latency  time.Duration
+	interval time.Duration
+	onChange func(rate float64)
+
+	mu           sync.Mutex
+	status       AdaptiveStatus
+	start        time.Time       // start of interval
+	latencies    []time.Duration // latencies of not throttled responses in interval
+	throttled    bool            // interval has throttling response
+	lastDecrease time.Time
+}
+
+// NewAdaptive returns controller of url settings (controller created from nil settings keeps rate 1),
+// onChange is called on each rate change.
+func NewAdaptive(cfg *entity.Adaptive, onChange func(rate float64)) *Adaptive {
+	now := time.Now()
+	a := &Adaptive{
+		enabled:  cfg != nil,
+		decrease: defaultDecrease,
+		increase: defaultIncrease,
+		min:      defaultMinRate,
+		interval: defaultAdaptInterval,
+		onChange: onChange,
+		status:   AdaptiveStatus{Enabled: cfg != nil, Rate: 1, Since: now},
+		start:    now,
+	}
+	if cfg == nil {
+		return a
+	}
+	if cfg.Decrease > 0 && cfg.Decrease < 1 {
+		a.decrease = cfg.Decrease
+	}
+	if cfg.Increase > 0 {
+		a.increase = cfg.Increase
+	}
+	if cfg.Min > 0 && cfg.Min <= 1 {
+		a.min = cfg.Min
+	}
+	if cfg.Latency > 0 {
+		a.latency = time.Duration(cfg.Latency) * time.Millisecond
+	}
+	if cfg.Interval > 0 {
+		a.interval = time.Duration(cfg.Interval) * time.Millisecond
+	}
+	return a
+}
+
+// Done reports response of url: status code (0 if request failed), delay asked by Retry-After header and latency.
+func (a *Adaptive) Done(code int, retryAfter, latency time.Duration) {
+	if !a.enabled {
+		return
+	}
+	a.mu.Lock()
+	now := time.Now()
+	changed := false
+	if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable || retryAfter > 0 {
+		a.throttled = true
+		if hold := now.Add(retryAfter); hold.After(a.status.Hold) {
+			a.status.Hold = hold
+		}
+		changed = a.decreaseRate(now)
+	} else {
+		a.latencies = append(a.latencies, latency)
+	}
+	if now.Sub(a.start) >= a.interval {
+		changed = a.adapt(now) || changed
+	}
+	rate := a.status.Rate
+	a.mu.Unlock()
+	if changed && a.onChange != nil { // called without lock: it may read status
+		a.onChange(rate)
+	}
+}
+
+// adapt ends interval: rate is decreased if p99 latency exceeds threshold and increased if interval
+// had no overload and Retry-After delay is over. Must be called under lock.
+func (a *Adaptive) adapt(now time.Time) bool {
+	p99 := percentile(a.latencies, 0.99)
+	throttled := a.throttled
+	a.status.P99 = float64(p99) / float64(time.Millisecond)
+	a.start, a.latencies, a.throttled = now, a.latencies[:0], false
+	switch {
+	case a.latency > 0 && p99 > a.latency:
+		return a.decreaseRate(now)
+	case throttled || now.Before(a.status.Hold) || a.status.Rate >= 1:
+		return false
+	default:
+		return a.setRate(math.Min(1, a.status.Rate+a.increase), now)
+	}
+}
+
+// decreaseRate decreases rate if it was not decreased during the last interval. Must be called under lock.
+func (a *Adaptive) decreaseRate(now time.Time) bool {
+	if now.Sub(a.lastDecrease) < a.interval {
+		return false
+	}
+	a.lastDecrease = now
+	return a.setRate(math.Max(a.min, a.status.Rate*a.decrease), now)
+}
+
+func (a *Adaptive) setRate(rate float64, now time.Time) bool {
+	if rate == a.status.Rate {
+		return false
+	}
+	a.status.Rate, a.status.Since = rate, now
+	return true
+}
+
+// Limit returns limit multiplied by current rate, at least 1 (not positive limit is unlimited and is not changed).
+func (a *Adaptive) Limit(limit int) int {
+	if limit <= 0 || !a.enabled {
+		return limit
+	}
+	a.mu.Lock()
+	rate := a.status.Rate
+	a.mu.Unlock()
+	adapted := int(math.Ceil(float64(limit) * rate))
+	if adapted < 1 {
+		adapted = 1
+	}
+	return adapted
+}
+
+func (a *Adaptive) Status() AdaptiveStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+// percentile returns p-th percentile of latencies, latencies are sorted.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+}
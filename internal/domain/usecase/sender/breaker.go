@@ -0,0 +1,164 @@
+package sender
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+const (
+	defaultFailureRate   = 0.5
+	defaultMinRequests   = 10
+	defaultWindow        = 10 * time.Second
+	defaultOpenTimeout   = 5 * time.Second
+	defaultProbeInterval = time.Second
+	defaultProbes        = 3
+)
+
+// ErrBreakerOpen is error of request short-circuited by open breaker.
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerStatus is current state of breaker.
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Requests int       `json:"requests"` // requests in current window (probes in half-open state)
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"` // time of last state change
+}
+
+// Breaker is circuit breaker of url: it opens when failure rate of requests in window exceeds threshold,
+// short-circuits requests while open and after timeout lets probe requests through at controlled rate.
+type Breaker struct {
+	enabled       bool
+	failureRate   float64
+	minRequests   int
+	window        time.Duration
+	openTimeout   time.Duration
+	probeInterval time.Duration
+	probes        int
+	onChange      func(state string)
+
+	mu          sync.Mutex
+	status      BreakerStatus
+	windowStart time.Time
+	nextProbe   time.Time
+}
+
+// NewBreaker returns breaker of url settings (breaker created from nil settings is always closed),
+// onChange is called on each state change.
+func NewBreaker(cfg *entity.Breaker, onChange func(state string)) *Breaker {
+	now := time.Now()
+	b := &Breaker{
+		enabled:       cfg != nil,
+		failureRate:   defaultFailureRate,
+		minRequests:   defaultMinRequests,
+		window:        defaultWindow,
+		openTimeout:   defaultOpenTimeout,
+		probeInterval: defaultProbeInterval,
+		probes:        defaultProbes,
+		onChange:      onChange,
+		status:        BreakerStatus{State: BreakerClosed, Since: now},
+		windowStart:   now,
+	}
+	if cfg == nil {
+		return b
+	}
+	if cfg.FailureRate > 0 {
+		b.failureRate = cfg.FailureRate
+	}
+	if cfg.MinRequests > 0 {
+		b.minRequests = cfg.MinRequests
+	}
+	if cfg.Window > 0 {
+		b.window = time.Duration(cfg.Window) * time.Millisecond
+	}
+	if cfg.OpenTimeout > 0 {
+		b.openTimeout = time.Duration(cfg.OpenTimeout) * time.Millisecond
+	}
+	if cfg.ProbeInterval > 0 {
+		b.probeInterval = time.Duration(cfg.ProbeInterval) * time.Millisecond
+	}
+	if cfg.Probes > 0 {
+		b.probes = cfg.Probes
+	}
+	return b
+}
+
+// Allow reports whether request can be sent now.
+func (b *Breaker) Allow() bool {
+	if !b.enabled {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.status.State {
+	case BreakerOpen:
+		if now.Sub(b.status.Since) < b.openTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if now.Before(b.nextProbe) {
+			return false
+		}
+		b.nextProbe = now.Add(b.probeInterval)
+		return true
+	default:
+		return true
+	}
+}
+
+// Done reports result of allowed request.
+func (b *Breaker) Done(success bool) {
+	if !b.enabled {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.status.State {
+	case BreakerHalfOpen:
+		if !success {
+			b.setState(BreakerOpen, now)
+			return
+		}
+		if b.status.Requests++; b.status.Requests >= b.probes {
+			b.setState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.window {
+			b.windowStart, b.status.Requests, b.status.Failures = now, 0, 0
+		}
+		b.status.Requests++
+		if !success {
+			b.status.Failures++
+		}
+		if b.status.Requests >= b.minRequests && float64(b.status.Failures) >= b.failureRate*float64(b.status.Requests) {
+			b.setState(BreakerOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *Breaker) setState(state string, now time.Time) {
+	b.status = BreakerStatus{State: state, Since: now}
+	b.windowStart, b.nextProbe = now, now
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
@@ -0,0 +1,142 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+const (
+	// HeaderRequestID is header of outgoing request with id of incoming one.
+	HeaderRequestID = "X-Request-Id"
+)
+
+const (
+	ErrURL      = "can't parse url %v"
+	ErrTemplate = "can't parse %v template of url %v"
+	ErrExecute  = "can't execute %v template of url %v"
+)
+
+// templateFuncs are functions available in templates in addition to text/template builtins.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Request is outgoing query to external url.
+type Request struct {
+	Method string
+	URL    string
+	Header map[string][]string
+	Body   []byte
+}
+
+// TemplateData is incoming query available in url and body templates,
+// e.g. {{.FeedID}}, {{.Query.Get "q"}}, {{.Header.Get "X-User"}}, {{.Time.Unix}}, {{json .Query}}.
+// Templates fail on missing keys of maps, so {{.Query.q}} requires param q while {{.Query.Get "q"}} may be empty.
+type TemplateData struct {
+	FeedID    string
+	RequestID string
+	Query     url.Values
+	Header    http.Header
+	Body      string
+	Time      time.Time
+}
+
+// RequestBuilder maps incoming messages onto outgoing requests to external url according to url settings.
+type RequestBuilder struct {
+	url  entity.URL
+	path *template.Template
+	body *template.Template
+}
+
+func NewRequestBuilder(u entity.URL) (*RequestBuilder, error) {
+	b := &RequestBuilder{url: u}
+	var err error
+	if b.path, err = template.New("url").Option("missingkey=error").Funcs(templateFuncs).Parse(u.Value); err != nil {
+		return nil, errors.Wrapf(err, ErrTemplate, "url", u.ID)
+	}
+	if u.Body != "" {
+		if b.body, err = template.New("body").Option("missingkey=error").Funcs(templateFuncs).Parse(u.Body); err != nil {
+			return nil, errors.Wrapf(err, ErrTemplate, "body", u.ID)
+		}
+	}
+	return b, nil
+}
+
+func (b *RequestBuilder) Build(msg entity.Message, now time.Time) (Request, error) {
+	data := TemplateData{
+		FeedID:    msg.FeedID,
+		RequestID: msg.RequestID,
+		Query:     msg.Query,
+		Header:    msg.Header,
+		Body:      string(msg.Body),
+		Time:      now,
+	}
+	value := &strings.Builder{}
+	if err := b.path.Execute(value, data); err != nil {
+		return Request{}, errors.Wrapf(err, ErrExecute, "url", b.url.ID)
+	}
+	target, err := url.Parse(value.String())
+	if err != nil {
+		return Request{}, errors.Wrapf(err, ErrURL, value.String())
+	}
+
+	req := Request{Method: b.url.Method, Header: make(map[string][]string)}
+	if req.Method == "" {
+		req.Method = msg.Method
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	query := target.Query()
+	switch params := b.url.Params; {
+	case params == nil:
+		for name, values := range msg.Query {
+			query[name] = append(query[name], values...)
+		}
+		req.Body = msg.Body
+	default:
+		for in, out := range params.Query {
+			if values, ok := msg.Query[in]; ok {
+				query[out] = append(query[out], values...)
+			}
+		}
+		for _, name := range params.Headers {
+			name = http.CanonicalHeaderKey(name)
+			if values, ok := msg.Header[name]; ok {
+				req.Header[name] = values
+			}
+		}
+		if params.Body {
+			req.Body = msg.Body
+		}
+	}
+	target.RawQuery = query.Encode()
+	req.URL = target.String()
+
+	if b.body != nil {
+		body := &bytes.Buffer{}
+		if err := b.body.Execute(body, data); err != nil {
+			return Request{}, errors.Wrapf(err, ErrExecute, "body", b.url.ID)
+		}
+		req.Body = body.Bytes()
+	}
+	for name, value := range b.url.Headers {
+		req.Header[http.CanonicalHeaderKey(name)] = []string{value}
+	}
+	if msg.RequestID != "" {
+		req.Header[HeaderRequestID] = []string{msg.RequestID}
+	}
+	return req, nil
+}
@@ -0,0 +1,82 @@
+package sender
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+)
+
+// defaultRetryCap is max backoff if url doesn't set it.
+const defaultRetryCap = time.Minute
+
+// defaultRetryCodes are retryable status codes if url doesn't set them.
+var defaultRetryCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy decides whether failed request to url is retried and when.
+type RetryPolicy struct {
+	attempts int
+	base     time.Duration
+	cap      time.Duration
+	jitter   float64
+	codes    map[int]bool
+}
+
+// NewRetryPolicy returns policy of url, requests are never retried by policy created from nil.
+func NewRetryPolicy(r *entity.Retry) *RetryPolicy {
+	p := &RetryPolicy{attempts: 1, codes: make(map[int]bool)}
+	if r == nil {
+		return p
+	}
+	p.attempts = r.Attempts
+	p.base = time.Duration(r.Base) * time.Millisecond
+	p.cap = time.Duration(r.Cap) * time.Millisecond
+	if p.cap <= 0 {
+		p.cap = defaultRetryCap
+	}
+	p.jitter = r.Jitter
+	codes := r.Codes
+	if len(codes) == 0 {
+		codes = defaultRetryCodes
+	}
+	for _, code := range codes {
+		p.codes[code] = true
+	}
+	return p
+}
+
+// Retryable reports whether request failed at attempt (starting from 1) with status code or error can be retried.
+// Request short-circuited by open breaker is not retried: backoff is shorter than open timeout of breaker usually,
+// so retries would be short-circuited too.
+func (p *RetryPolicy) Retryable(attempt, code int, err error) bool {
+	if attempt >= p.attempts || err == ErrBreakerOpen {
+		return false
+	}
+	return err != nil || p.codes[code]
+}
+
+// Backoff returns delay before retry of request failed at attempt (starting from 1). Delay asked by
+// Retry-After header of response is respected if it is longer.
+func (p *RetryPolicy) Backoff(attempt int, retryAfter time.Duration) time.Duration {
+	d := p.base
+	for i := 1; i < attempt && d < p.cap; i++ {
+		d *= 2
+	}
+	if d > p.cap {
+		d = p.cap
+	}
+	if p.jitter > 0 {
+		d = time.Duration(float64(d) * (1 - p.jitter + p.jitter*rand.Float64())) //nolint:gosec
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d
+}
@@ -4,11 +4,27 @@ import (
 	"context"
 	"time"
 
+	"github.com/shipa988/fanouter/internal/domain/entity"
 	"github.com/shipa988/fanouter/internal/domain/usecase"
 )
 
+// Options is parameters of query sender.
+type Options struct {
+	Timeout  time.Duration
+	PoolSize int
+	Logger   usecase.Logger
+	Metrics  usecase.Metrics
+	// Requeue queues message again to limiter of its feed (retries consume limiter tokens).
+	Requeue func(ctx context.Context, msg entity.Message) error
+	Breaker *Breaker
+	// Adaptive adapts rate of url to its responses.
+	Adaptive *Adaptive
+	// DeadLetters stores queries which can't be delivered, may be nil.
+	DeadLetters entity.DeadLetterRepo
+}
+
 // QuerySender is abstract query sender (for using different module/frameworks/plugins of sending client queries? m.b fasthttp Client?).
 type QuerySender interface {
-	Send(ctx context.Context, url string, in <-chan string)
-	Init(timeout time.Duration, poolSize int, logger usecase.Logger)
+	Send(ctx context.Context, url entity.URL, in <-chan entity.Message)
+	Init(opts Options)
 }
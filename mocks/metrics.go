@@ -0,0 +1,54 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/shipa988/fanouter/internal/domain/usecase"
+)
+
+var _ usecase.Metrics = (*MockMetrics)(nil)
+
+type MockMetrics struct{}
+
+func NewMockMetrics() *MockMetrics {
+	return &MockMetrics{}
+}
+
+func (m MockMetrics) Fanout(feedID string) {
+}
+
+func (m MockMetrics) Enqueued(urlID, feedID string) {
+}
+
+func (m MockMetrics) Dropped(urlID, feedID string) {
+}
+
+func (m MockMetrics) QueueDepth(urlID, feedID string, depth int) {
+}
+
+func (m MockMetrics) Limit(urlID, feedID string, limit int) {
+}
+
+func (m MockMetrics) Sent(urlID, feedID string) {
+}
+
+func (m MockMetrics) Retry(urlID, feedID string) {
+}
+
+func (m MockMetrics) ShortCircuit(urlID, feedID string) {
+}
+
+func (m MockMetrics) DeadLetter(urlID, feedID string) {
+}
+
+func (m MockMetrics) QuotaRemaining(urlID, feedID string, remaining int64) {
+}
+
+func (m MockMetrics) BreakerState(urlID, state string) {
+}
+
+func (m MockMetrics) Request(urlID, feedID string, code int, latency time.Duration) {
+}
+
+func (m MockMetrics) Remove(urlID, feedID string) {
+}
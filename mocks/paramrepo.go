@@ -1,22 +1,39 @@
 package mocks
 
 import (
-	"github.com/shipa988/fanouter/internal/domain/entity"
+	"encoding/json"
 	"strconv"
+	"sync"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
 )
 
 var _ entity.FanParamRepo = (*MockRepo)(nil)
 
 type MockRepo struct {
-	urls   []string
-	feedID string
-	limit  int
+	urls      []string
+	feedID    string
+	limit     int
+	algorithm string
+	burst     int
 }
 
 func NewMockRepo(urls []string, feedID string, limit int) *MockRepo {
 	return &MockRepo{urls: urls, feedID: feedID, limit: limit}
 }
 
+// WithAlgorithm sets limiting algorithm of feed.
+func (m *MockRepo) WithAlgorithm(algorithm string) *MockRepo {
+	m.algorithm = algorithm
+	return m
+}
+
+// WithBurst sets max burst of feed.
+func (m *MockRepo) WithBurst(burst int) *MockRepo {
+	m.burst = burst
+	return m
+}
+
 func (m *MockRepo) Load() (*entity.FanParam, error) {
 	p := entity.FanParam{
 		TimeOut:  10,
@@ -28,7 +45,7 @@ func (m *MockRepo) Load() (*entity.FanParam, error) {
 			ID:    strconv.Itoa(i),
 			Value: url,
 			Feeds: []entity.Feed{
-				{ID: m.feedID, Limit: strconv.Itoa(m.limit)},
+				{ID: m.feedID, Limit: strconv.Itoa(m.limit), Algorithm: m.algorithm, Burst: m.burst},
 			},
 		}
 
@@ -36,3 +53,37 @@ func (m *MockRepo) Load() (*entity.FanParam, error) {
 	}
 	return &p, nil
 }
+
+var _ entity.FanParamRepo = (*StaticRepo)(nil)
+
+// StaticRepo returns copy of parameters set by Set, so changed parameters are loaded by the next reload.
+type StaticRepo struct {
+	mu     sync.Mutex
+	params []byte
+}
+
+func NewStaticRepo(params *entity.FanParam) *StaticRepo {
+	r := &StaticRepo{}
+	r.Set(params)
+	return r
+}
+
+func (r *StaticRepo) Set(params *entity.FanParam) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		panic(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.params = b
+}
+
+func (r *StaticRepo) Load() (*entity.FanParam, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	params := &entity.FanParam{}
+	if err := json.Unmarshal(r.params, params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
@@ -0,0 +1,177 @@
+package mocks
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRedis is in-process server speaking redis protocol with sorted set commands used by shared limiter store
+// (ZREMRANGEBYSCORE with exclusive max, ZADD, ZCARD, ZRANGE of the first entry, ZREM) and PEXPIRE, AUTH, SELECT, PING.
+type FakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	sets     map[string]map[string]float64
+	expires  map[string]time.Time
+}
+
+func NewFakeRedis() (*FakeRedis, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &FakeRedis{listener: l, sets: make(map[string]map[string]float64), expires: make(map[string]time.Time)}
+	go r.serve()
+	return r, nil
+}
+
+func (r *FakeRedis) Addr() string {
+	return r.listener.Addr().String()
+}
+
+func (r *FakeRedis) Close() error {
+	return r.listener.Close()
+}
+
+func (r *FakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *FakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, r.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (r *FakeRedis) exec(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "ZREMRANGEBYSCORE":
+		max, err := strconv.ParseFloat(strings.TrimPrefix(args[3], "("), 64)
+		if err != nil {
+			return "-ERR max is not a float\r\n"
+		}
+		removed := 0
+		for member, score := range r.set(args[1]) {
+			if score < max {
+				delete(r.sets[args[1]], member)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "ZADD":
+		score, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return "-ERR value is not a valid float\r\n"
+		}
+		set := r.set(args[1])
+		_, ok := set[args[3]]
+		set[args[3]] = score
+		if ok {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(r.set(args[1])))
+	case "ZRANGE": // only the first entry with score
+		var (
+			first string
+			min   float64
+		)
+		for member, score := range r.set(args[1]) {
+			if first == "" || score < min || (score == min && member < first) {
+				first, min = member, score
+			}
+		}
+		if first == "" {
+			return "*0\r\n"
+		}
+		value := strconv.FormatFloat(min, 'f', -1, 64)
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(first), first, len(value), value)
+	case "ZREM":
+		set := r.set(args[1])
+		if _, ok := set[args[2]]; !ok {
+			return ":0\r\n"
+		}
+		delete(set, args[2])
+		return ":1\r\n"
+	case "PEXPIRE":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		if len(r.set(args[1])) == 0 {
+			return ":0\r\n"
+		}
+		r.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// set returns sorted set of key, expired set is replaced by empty one.
+func (r *FakeRedis) set(key string) map[string]float64 {
+	if exp, ok := r.expires[key]; ok && time.Now().After(exp) {
+		delete(r.sets, key)
+		delete(r.expires, key)
+	}
+	set, ok := r.sets[key]
+	if !ok {
+		set = make(map[string]float64)
+		r.sets[key] = set
+	}
+	return set
+}
+
+// readCommand reads command sent as array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
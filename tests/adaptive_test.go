@@ -0,0 +1,85 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestAdaptive(t *testing.T) {
+	const (
+		urlLimit  = 20
+		feedLimit = 40
+		interval  = 200 * time.Millisecond
+	)
+	start := func(t *testing.T, target *Target, cfg *entity.Adaptive) (*fanouter.FanoutInteractor, context.Context, context.CancelFunc) {
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Limit: fmt.Sprint(urlLimit),
+			Adaptive: cfg, Feeds: []entity.Feed{{ID: "adaptive", Limit: fmt.Sprint(feedLimit)}}})))
+		ctx, cancel := context.WithCancel(context.Background())
+		require.Nil(t, fanOuter.Init(ctx))
+		return fanOuter, ctx, cancel
+	}
+	rate := func(t *testing.T, f *fanouter.FanoutInteractor, ctx context.Context, want float64) {
+		r, err := f.Rate(ctx, "u")
+		require.Nil(t, err)
+		require.True(t, r.Enabled)
+		require.InDelta(t, want, r.Rate, 0.001)
+		require.Equal(t, urlLimit, r.Limit)
+		require.Equal(t, int(urlLimit*want), r.Effective, "effective limit of url should follow rate")
+		require.Equal(t, []fanouter.FeedRate{{ID: "adaptive", Profile: fanouter.DefaultProfile, Limit: feedLimit, Effective: int(feedLimit * want)}}, r.Feeds,
+			"effective limit of feed should follow rate")
+	}
+
+	t.Run("throttling", func(t *testing.T) {
+		var throttled int32 = 1
+		target := NewTarget(func(body string, n int) int {
+			if atomic.LoadInt32(&throttled) == 1 {
+				return http.StatusTooManyRequests
+			}
+			return http.StatusOK
+		})
+		defer target.Close()
+		fanOuter, ctx, cancel := start(t, target, &entity.Adaptive{Decrease: 0.5, Increase: 0.25, Min: 0.25, Interval: int(interval / time.Millisecond)})
+		defer cancel()
+
+		drive(ctx, fanOuter, feedLimit, 2*time.Second, "adaptive")
+		rate(t, fanOuter, ctx, 0.25) // decreased multiplicatively on throttling down to min
+		received := target.CountSince("adaptive", time.Now().Add(-time.Second))
+		fmt.Printf("qps while throttled: %v\n", received)
+		require.LessOrEqual(t, received, urlLimit/4+2, "qps should follow decreased rate")
+
+		atomic.StoreInt32(&throttled, 0)
+		drive(ctx, fanOuter, feedLimit, 3*time.Second, "adaptive")
+		rate(t, fanOuter, ctx, 1) // increased additively without throttling up to configured limits
+		received = target.CountSince("adaptive", time.Now().Add(-time.Second))
+		fmt.Printf("qps after recovery: %v\n", received)
+		require.GreaterOrEqual(t, float64(received), urlLimit*0.85, "qps should recover to configured limit")
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		target := NewTarget(func(body string, n int) int {
+			time.Sleep(50 * time.Millisecond)
+			return http.StatusOK
+		})
+		defer target.Close()
+		fanOuter, ctx, cancel := start(t, target, &entity.Adaptive{Decrease: 0.5, Min: 0.5, Latency: 20, Interval: int(interval / time.Millisecond)})
+		defer cancel()
+
+		drive(ctx, fanOuter, feedLimit, time.Second, "adaptive")
+		rate(t, fanOuter, ctx, 0.5) // decreased when p99 latency of interval exceeds threshold
+		r, err := fanOuter.Rate(ctx, "u")
+		require.Nil(t, err)
+		require.Greater(t, r.P99, float64(20))
+	})
+}
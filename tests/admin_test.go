@@ -0,0 +1,113 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+)
+
+// do sends admin request with json body and returns status code.
+func do(t *testing.T, method, url, body string) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminCRUD(t *testing.T) {
+	first, second := NewTarget(nil), NewTarget(nil)
+	defer first.Close()
+	defer second.Close()
+	dir, err := ioutil.TempDir("", "urls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "urls.json")
+	b, err := json.Marshal(params(entity.URL{ID: "first", Value: first.URL, Feeds: []entity.Feed{{ID: "a", Limit: "100"}}}))
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(path, b, 0644))
+
+	fanOuter := newFanouter(repository.NewJSONRepo(path))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+	addr, stop := serve(t, fanOuter)
+	defer stop()
+	stored := func() *entity.FanParam {
+		params, err := repository.NewJSONRepo(path).Load()
+		require.Nil(t, err)
+		return params
+	}
+	sent := func(t *testing.T, feedID string, target *Target, msg string) {
+		body := feedID + "-" + time.Now().String()
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(body)})
+		require.Nil(t, err, msg)
+		require.Eventually(t, func() bool { return target.Count(body) == 1 }, 3*time.Second, 20*time.Millisecond, msg)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, do(t, http.MethodPost, addr+"/admin/urls",
+			`{"id": "second", "value": "`+second.URL+`", "feeds": [{"id": "b", "limit": "100"}]}`))
+		require.Equal(t, http.StatusCreated, do(t, http.MethodPost, addr+"/admin/urls/first/feeds", `{"id": "b", "limit": "100"}`))
+		require.Equal(t, http.StatusConflict, do(t, http.MethodPost, addr+"/admin/urls/first/feeds", `{"id": "b", "limit": "100"}`))
+		require.Equal(t, http.StatusNotFound, do(t, http.MethodPost, addr+"/admin/urls/third/feeds", `{"id": "b", "limit": "100"}`))
+		require.Len(t, stored().URLs, 2)
+		sent(t, "b", first, "created feed should be applied to running fanouter")
+		sent(t, "b", second, "created url should be applied to running fanouter")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, do(t, http.MethodPut, addr+"/admin/urls/first/feeds/a", `{"limit": "7"}`))
+		require.Equal(t, "7", stored().URLs[0].Feeds[0].Limit)
+		rate, err := fanOuter.Rate(ctx, "first")
+		require.Nil(t, err)
+		require.Equal(t, "a", rate.Feeds[0].ID)
+		require.Equal(t, 7, rate.Feeds[0].Limit, "changed limit should be applied to running limiter")
+		require.Equal(t, http.StatusOK, do(t, http.MethodPut, addr+"/admin/urls/second", `{"value": "`+second.URL+`", "limit": "50"}`))
+		require.Len(t, stored().URLs[1].Feeds, 1, "feeds of url should be kept if body has no feeds")
+	})
+
+	t.Run("invalid change is not stored", func(t *testing.T) {
+		before, err := ioutil.ReadFile(path)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusBadRequest, do(t, http.MethodPut, addr+"/admin/urls/first/feeds/a", `{"limit": "ten"}`))
+		require.Equal(t, http.StatusBadRequest, do(t, http.MethodPost, addr+"/admin/urls", `{"id": "third", "value": "`+first.URL+`", "feeds": [{"id": "c"}]}`))
+		require.Equal(t, http.StatusBadRequest, do(t, http.MethodPut, addr+"/admin/urls/first/feeds/a", `{"limit": "10", "limt": "20"}`))
+		after, err := ioutil.ReadFile(path)
+		require.Nil(t, err)
+		require.Equal(t, string(before), string(after))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, do(t, http.MethodDelete, addr+"/admin/urls/first/feeds/b", ""))
+		require.Equal(t, http.StatusNotFound, do(t, http.MethodDelete, addr+"/admin/urls/first/feeds/b", ""))
+		require.Equal(t, http.StatusOK, do(t, http.MethodDelete, addr+"/admin/urls/second", ""))
+		require.Len(t, stored().URLs, 1)
+		require.Len(t, stored().URLs[0].Feeds, 1)
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "b", Body: []byte("b")})
+		require.Equal(t, fanouter.ErrNotFound, err, "deleted feed should be removed from running fanouter")
+	})
+
+	t.Run("atomic rewrite", func(t *testing.T) {
+		_, err := os.Stat(path + ".tmp")
+		require.True(t, os.IsNotExist(err), "temporary file should be renamed to urls json")
+		b, err := ioutil.ReadFile(path)
+		require.Nil(t, err)
+		require.True(t, json.Valid(b))
+	})
+}
@@ -0,0 +1,72 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestBreaker(t *testing.T) {
+	const openTimeout = 500 * time.Millisecond
+	var down int32 = 1
+	target := NewTarget(func(body string, n int) int {
+		if atomic.LoadInt32(&down) == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	defer target.Close()
+	fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+		Breaker: &entity.Breaker{FailureRate: 0.5, MinRequests: 4, OpenTimeout: int(openTimeout / time.Millisecond), ProbeInterval: 100, Probes: 2},
+		Feeds:   []entity.Feed{{ID: "breaker", Limit: "100"}}})))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+
+	send := func(count int) {
+		for i := 0; i < count; i++ {
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "breaker", Body: []byte("breaker")})
+			require.Nil(t, err)
+		}
+		time.Sleep(150 * time.Millisecond)
+	}
+	status := func(state string, sent int) sender.BreakerStatus {
+		s, err := fanOuter.Breaker(ctx, "u")
+		require.Nil(t, err)
+		require.Equal(t, state, s.State)
+		require.Equal(t, sent, target.Count("breaker"), "queries sent to url")
+		return s
+	}
+	waitOpenTimeout := func(s sender.BreakerStatus) {
+		time.Sleep(time.Until(s.Since.Add(openTimeout + 50*time.Millisecond)))
+	}
+
+	send(4)
+	opened := status(sender.BreakerOpen, 4) // failure rate of min requests reached threshold
+	send(3)
+	status(sender.BreakerOpen, 4) // open breaker short-circuits queries
+
+	waitOpenTimeout(opened)
+	send(1)
+	opened = status(sender.BreakerOpen, 5) // failed probe opens breaker again
+
+	atomic.StoreInt32(&down, 0)
+	waitOpenTimeout(opened)
+	send(2)
+	s := status(sender.BreakerHalfOpen, 6) // the second query waits for probe interval and is short-circuited
+	require.Equal(t, 1, s.Requests)
+	send(1)
+	status(sender.BreakerClosed, 7) // successful probes close breaker
+	send(3)
+	status(sender.BreakerClosed, 10)
+}
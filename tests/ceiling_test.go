@@ -0,0 +1,133 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestCeilings(t *testing.T) {
+	const (
+		duration = 3 * time.Second
+		warmup   = time.Second // rate is measured after warmup
+		qps      = 40          // of each feed, more then any ceiling
+	)
+	type count struct { // queries of feeds received by urls
+		urls  []int
+		feeds []string
+	}
+	tcases := []struct {
+		name   string
+		params func(urls ...string) *entity.FanParam
+		feeds  []string // fanned out feeds
+		counts []count  // counted received queries
+		want   []int    // ceiling of qps of each count
+		total  int      // expected qps of all urls
+	}{
+		{
+			name: "url limit shared by its feeds",
+			params: func(urls ...string) *entity.FanParam {
+				return params(entity.URL{ID: "u0", Value: urls[0], Limit: "20", Feeds: []entity.Feed{{ID: "a", Limit: "50"}, {ID: "b", Limit: "50"}}})
+			},
+			feeds:  []string{"a", "b"},
+			counts: []count{{urls: []int{0}, feeds: []string{"a", "b"}}},
+			want:   []int{20},
+			total:  20,
+		},
+		{
+			name: "feed limit shared by its urls",
+			params: func(urls ...string) *entity.FanParam {
+				p := params(
+					entity.URL{ID: "u0", Value: urls[0], Feeds: []entity.Feed{{ID: "a", Limit: "50"}}},
+					entity.URL{ID: "u1", Value: urls[1], Feeds: []entity.Feed{{ID: "a", Limit: "50"}}},
+				)
+				p.Feeds = []entity.FeedLimit{{ID: "a", Limit: "20"}}
+				return p
+			},
+			feeds:  []string{"a"},
+			counts: []count{{urls: []int{0, 1}, feeds: []string{"a"}}},
+			want:   []int{20},
+			total:  20,
+		},
+		{
+			name: "global limit shared by all urls and feeds",
+			params: func(urls ...string) *entity.FanParam {
+				p := params(
+					entity.URL{ID: "u0", Value: urls[0], Feeds: []entity.Feed{{ID: "a", Limit: "50"}}},
+					entity.URL{ID: "u1", Value: urls[1], Feeds: []entity.Feed{{ID: "b", Limit: "50"}}},
+				)
+				p.Limit = "20"
+				return p
+			},
+			feeds:  []string{"a", "b"},
+			counts: []count{{urls: []int{0, 1}, feeds: []string{"a", "b"}}},
+			want:   []int{20},
+			total:  20,
+		},
+		{
+			name: "all ceilings together",
+			params: func(urls ...string) *entity.FanParam {
+				p := params(
+					entity.URL{ID: "u0", Value: urls[0], Limit: "15", Feeds: []entity.Feed{{ID: "a", Limit: "50"}, {ID: "b", Limit: "50"}}},
+					entity.URL{ID: "u1", Value: urls[1], Feeds: []entity.Feed{{ID: "c", Limit: "50"}}},
+				)
+				p.Limit = "20"
+				p.Feeds = []entity.FeedLimit{{ID: "c", Limit: "8"}}
+				return p
+			},
+			feeds: []string{"a", "b", "c"},
+			counts: []count{
+				{urls: []int{0}, feeds: []string{"a", "b"}},
+				{urls: []int{1}, feeds: []string{"c"}},
+			},
+			want:  []int{15, 8},
+			total: 20, // url and feed ceilings allow 15+8, global one is lower
+		},
+	}
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			targets := []*Target{NewTarget(nil), NewTarget(nil)}
+			defer func() {
+				for _, target := range targets {
+					target.Close()
+				}
+			}()
+			fanOuter := newFanouter(mocks.NewStaticRepo(tcase.params(targets[0].URL, targets[1].URL)))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			require.Nil(t, fanOuter.Init(ctx))
+
+			start := time.Now()
+			require.Empty(t, drive(ctx, fanOuter, qps, duration, tcase.feeds...))
+			cancel()
+
+			seconds := (duration - warmup).Seconds()
+			received := func(c count) int {
+				n := 0
+				for _, url := range c.urls {
+					for _, feed := range c.feeds {
+						n += targets[url].CountSince(feed, start.Add(warmup)) - targets[url].CountSince(feed, start.Add(duration))
+					}
+				}
+				return n
+			}
+			for i, c := range tcase.counts {
+				n := received(c)
+				fmt.Printf("%v: urls %v feeds %v - qps=%.1f, ceiling=%v\n", tcase.name, c.urls, c.feeds, float64(n)/seconds, tcase.want[i])
+				require.LessOrEqualf(t, float64(n), float64(tcase.want[i])*seconds*1.1, "qps of urls %v feeds %v should not exceed ceiling", c.urls, c.feeds)
+			}
+			total := received(count{urls: []int{0, 1}, feeds: tcase.feeds})
+			fmt.Printf("%v: total qps=%.1f, expected=%v\n", tcase.name, float64(total)/seconds, tcase.total)
+			require.GreaterOrEqualf(t, float64(total), float64(tcase.total)*seconds*0.85, "combined rate should reach ceilings")
+			require.LessOrEqualf(t, float64(total), float64(tcase.total)*seconds*1.1, "combined rate should not exceed ceilings")
+		})
+	}
+}
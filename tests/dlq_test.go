@@ -0,0 +1,123 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	controllers "github.com/shipa988/fanouter/internal/data/controller"
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dlq := func(t *testing.T) (string, func()) {
+		dir, err := ioutil.TempDir("", "dlq")
+		require.Nil(t, err)
+		return filepath.Join(dir, "dlq.json"), func() { os.RemoveAll(dir) }
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		var down int32 = 1
+		target := NewTarget(func(body string, n int) int {
+			if atomic.LoadInt32(&down) == 1 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusOK
+		})
+		defer target.Close()
+		path, remove := dlq(t)
+		defer remove()
+		letters := repository.NewDLQRepo(path)
+		require.Nil(t, letters.Put(entity.DeadLetter{URLID: "gone", FeedID: "dead", Message: entity.Message{FeedID: "dead", Body: []byte("gone")}}))
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+			Retry: &entity.Retry{Attempts: 1}, Feeds: []entity.Feed{{ID: "dead", Limit: "100"}}})))
+		fanOuter.WithDeadLetters(letters)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+		addr, stop := serve(t, fanOuter)
+		defer stop()
+
+		for i := 0; i < 3; i++ {
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "dead", Body: []byte("dead-" + strconv.Itoa(i))})
+			require.Nil(t, err)
+		}
+		time.Sleep(300 * time.Millisecond)
+		list, err := letters.List()
+		require.Nil(t, err)
+		require.Len(t, list, 4, "failed queries should become dead letters")
+
+		atomic.StoreInt32(&down, 0)
+		resp, err := http.Post(addr+"/admin/dlq/replay", "application/json", nil)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		answer := controllers.ReplayAnswer{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&answer))
+		require.Equal(t, 3, answer.Replayed, "letters of running urls should be replayed")
+		time.Sleep(300 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			require.Equal(t, 2, target.Count("dead-"+strconv.Itoa(i)), "replayed letter should be sent again")
+		}
+		list, err = letters.List()
+		require.Nil(t, err)
+		require.Len(t, list, 1, "replayed letters should be deleted")
+		require.Equal(t, "gone", list[0].URLID, "letter of unknown url should stay")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		path, remove := dlq(t)
+		defer remove()
+		letters := repository.NewDLQRepo(path)
+		require.Nil(t, letters.Put(entity.DeadLetter{URLID: "u", FeedID: "dead"}))
+		require.Nil(t, letters.Purge())
+		list, err := letters.List()
+		require.Nil(t, err)
+		require.Empty(t, list, "purged letters should be deleted")
+		require.Nil(t, letters.Purge(), "purge of empty store should not fail")
+	})
+
+	t.Run("shared file", func(t *testing.T) {
+		const puts = 200
+		path, remove := dlq(t)
+		defer remove()
+		service, command := repository.NewDLQRepo(path), repository.NewDLQRepo(path) // as in different processes
+		require.Nil(t, service.Put(entity.DeadLetter{URLID: "old"}))
+		old, err := command.List()
+		require.Nil(t, err)
+		require.Len(t, old, 1)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < puts; i++ {
+				require.Nil(t, service.Put(entity.DeadLetter{URLID: "new"}))
+			}
+		}()
+	rewrite:
+		for {
+			select {
+			case <-done:
+				break rewrite
+			default:
+				require.Nil(t, command.Delete(old[0].ID))
+			}
+		}
+		list, err := command.List()
+		require.Nil(t, err)
+		require.Len(t, list, puts, "letters stored while file is rewritten should not be lost")
+	})
+}
@@ -0,0 +1,63 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	controllers "github.com/shipa988/fanouter/internal/data/controller"
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestDurableReplay(t *testing.T) {
+	const queries = 5
+	dir, err := ioutil.TempDir("", "queue")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	target := NewTarget(nil)
+	defer target.Close()
+	repo := mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{{ID: feedID, Limit: "1", Durable: true}}}))
+	start := func(ctx context.Context) *fanouter.FanoutInteractor {
+		fabrics := limiter.NewDefaultFabrics().WithJournals(repository.NewWALFabric(dir)) // log is reopened after restart
+		fanOuter := fanouter.NewFanoutInteractor(repo, controllers.NewHTTPClientFabric(), fabrics, mocks.NewMockLogger(), mocks.NewMockMetrics())
+		require.Nil(t, fanOuter.Init(ctx))
+		return fanOuter
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fanOuter := start(ctx)
+	for i := 0; i < queries; i++ {
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(strconv.Itoa(i))})
+		require.Nil(t, err)
+	}
+	cancel() // stop before the first query is sent
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 0, target.Count("0"))
+
+	repo.Set(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{{ID: feedID, Limit: "50", Durable: true}}}))
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	start(ctx)
+	require.Eventually(t, func() bool {
+		for i := 0; i < queries; i++ {
+			if target.Count(strconv.Itoa(i)) == 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond, "queries stored before stop should be replayed")
+	for i := 0; i < queries; i++ {
+		require.Equal(t, 1, target.Count(strconv.Itoa(i)), "query should be sent once")
+	}
+}
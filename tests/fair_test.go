@@ -0,0 +1,46 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestFairShare(t *testing.T) {
+	const (
+		duration = 4 * time.Second
+		warmup   = time.Second
+		urlLimit = 20
+	)
+	target := NewTarget(nil)
+	defer target.Close()
+	fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Limit: fmt.Sprint(urlLimit), Feeds: []entity.Feed{
+		{ID: "a", Limit: "50", Share: 1},
+		{ID: "b", Limit: "50", Share: 3},
+	}})))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+
+	start := time.Now()
+	require.Empty(t, drive(ctx, fanOuter, 40, duration, "a", "b")) // both feeds are above url limit
+	cancel()
+
+	received := func(feed string) int {
+		return target.CountSince(feed, start.Add(warmup)) - target.CountSince(feed, start.Add(duration))
+	}
+	a, b := received("a"), received("b")
+	seconds := (duration - warmup).Seconds()
+	fmt.Printf("url limit %v shared 1:3 - qps of a=%.1f, qps of b=%.1f\n", urlLimit, float64(a)/seconds, float64(b)/seconds)
+	require.LessOrEqualf(t, float64(a+b), urlLimit*seconds*1.1, "url limit should not be exceeded")
+	require.GreaterOrEqualf(t, float64(a+b), urlLimit*seconds*0.9, "url limit should be used")
+	require.InDeltaf(t, 3, float64(b)/float64(a), 0.5, "url limit should be shared by weights of feeds")
+}
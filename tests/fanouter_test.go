@@ -4,18 +4,27 @@ package tests
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
 	controllers "github.com/shipa988/fanouter/internal/data/controller"
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
 	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
 	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
 	"github.com/shipa988/fanouter/mocks"
@@ -24,6 +33,8 @@ import (
 const (
 	feedID = "1"
 	limit  = 50
+	// windowSlack is measurement error of rolling window (network and scheduling jitter between limiter and server).
+	windowSlack = 50 * time.Millisecond
 )
 
 var (
@@ -34,13 +45,87 @@ var (
 type ServerCheck struct {
 	server       *httptest.Server
 	queriesCount int32
+	mu           sync.Mutex
+	hits         []time.Time
 }
 
 func (c *ServerCheck) AddLimit() {
 	atomic.AddInt32(&c.queriesCount, 1)
+	c.mu.Lock()
+	c.hits = append(c.hits, time.Now())
+	c.mu.Unlock()
 }
 func (c *ServerCheck) ClearLimit() {
 	atomic.StoreInt32(&c.queriesCount, 0)
+	c.mu.Lock()
+	c.hits = nil
+	c.mu.Unlock()
+}
+
+// MaxRollingWindow returns max count of queries received during any rolling window.
+func (c *ServerCheck) MaxRollingWindow(window time.Duration) int {
+	c.mu.Lock()
+	hits := append([]time.Time(nil), c.hits...)
+	c.mu.Unlock()
+	return maxRollingWindow(hits, window)
+}
+
+// maxRollingWindow returns max count of hits during any rolling window.
+func maxRollingWindow(hits []time.Time, window time.Duration) int {
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Before(hits[j]) })
+	max := 0
+	for i, j := 0, 0; j < len(hits); j++ {
+		for hits[j].Sub(hits[i]) >= window {
+			i++
+		}
+		if j-i+1 > max {
+			max = j - i + 1
+		}
+	}
+	return max
+}
+
+// limiterHits pushes queries to every limiter with qps during duration, returns times when limiters sent them.
+// Limits are checked on output of limiters as delivery of queries to servers is delayed by senders unevenly.
+func limiterHits(limiters []limiter.QPSLimiter, limit, qps int, duration time.Duration) []time.Time {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mu   sync.Mutex
+		hits []time.Time
+	)
+	for _, l := range limiters {
+		out := make(chan entity.Message) // unbuffered: query is received when limiter sends it
+		l.Init(out, limit)
+		go l.DoLimiting(ctx)
+		go func() {
+			for {
+				select {
+				case <-out:
+					mu.Lock()
+					hits = append(hits, time.Now())
+					mu.Unlock()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(qps))
+	defer ticker.Stop()
+	stop := time.After(duration)
+	for {
+		select {
+		case <-stop:
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]time.Time(nil), hits...)
+		case <-ticker.C:
+			for _, l := range limiters {
+				l.Push(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)}) //nolint:errcheck // queries over queue size are dropped
+			}
+		}
+	}
 }
 func (c *ServerCheck) GetLimit() int32 {
 	return atomic.LoadInt32(&c.queriesCount)
@@ -49,6 +134,7 @@ func (c *ServerCheck) GetLimit() int32 {
 type Suite struct {
 	suite.Suite
 	servers  []*ServerCheck
+	urls     []string
 	fanOuter *fanouter.FanoutInteractor
 }
 
@@ -73,12 +159,13 @@ func (s *Suite) SetupSuite() {
 		urls = append(urls, servCh.server.URL)
 	}
 
+	s.urls = urls
 	logger := mocks.NewMockLogger()                   //for logging
 	urlRepo := mocks.NewMockRepo(urls, feedID, limit) //for loading fanout parameters
 	senderFabric := controllers.NewHTTPClientFabric() //senders creating inside fanOuter
 	qpsLimiterFabric := limiter.NewCLimiterFabric()   //limiters creating inside fanOuter
 
-	s.fanOuter = fanouter.NewFanoutInteractor(urlRepo, senderFabric, qpsLimiterFabric, logger)
+	s.fanOuter = fanouter.NewFanoutInteractor(urlRepo, senderFabric, qpsLimiterFabric, logger, mocks.NewMockMetrics())
 	s.AfterTest("", "")
 }
 
@@ -182,7 +269,7 @@ func (s *Suite) TestClient() {
 				case <-ct.Done():
 					break send_loop
 				case <-transmitQueryTicker.C:
-					err := s.fanOuter.Fanout(ct, tcase.feedID) //fanout received query to external url
+					_, err := s.fanOuter.Fanout(ct, entity.Message{FeedID: tcase.feedID, Body: []byte(tcase.feedID)}) //fanout received query to external url
 					requests++
 					if tcase.err {
 						require.NotNil(s.T(), err)
@@ -215,3 +302,239 @@ func (s *Suite) TestClient() {
 		maxQPS = 0
 	}
 }
+
+func (s *Suite) TestRollingWindow() {
+	const duration = 3 * time.Second
+	for _, algorithm := range []string{limiter.AlgorithmSlidingLog, limiter.AlgorithmGCRA} {
+		s.Run(algorithm, func() {
+			urlRepo := mocks.NewMockRepo(s.urls, feedID, limit).WithAlgorithm(algorithm)
+			fanOuter := fanouter.NewFanoutInteractor(urlRepo, controllers.NewHTTPClientFabric(), limiter.NewDefaultFabrics(), mocks.NewMockLogger(), mocks.NewMockMetrics())
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			require.Nil(s.T(), fanOuter.Init(ctx))
+
+			transmitQueryTicker := time.NewTicker(time.Second / (4 * limit)) // 4 times more then limit
+			stop := time.After(duration)
+		send_loop:
+			for {
+				select {
+				case <-stop:
+					break send_loop
+				case <-transmitQueryTicker.C:
+					_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+					require.Nil(s.T(), err)
+				}
+			}
+			transmitQueryTicker.Stop()
+			cancel()
+			for i, serverch := range s.servers {
+				receivedQueries := serverch.GetLimit()
+				fmt.Printf("%v server #%v - incoming request count=%v\n", algorithm, i, receivedQueries)
+				require.GreaterOrEqualf(s.T(), float64(receivedQueries), float64(limit)*duration.Seconds()*0.9, "90% (qps limit*duration) requests should be received by server")
+			}
+			for _, serverch := range s.servers {
+				serverch.ClearLimit()
+			}
+
+			l, err := limiter.NewDefaultFabrics().NewQPSLimiter(limiter.Config{FeedID: feedID, Algorithm: algorithm, Metrics: mocks.NewMockMetrics()})
+			require.Nil(s.T(), err)
+			maxWindow := maxRollingWindow(limiterHits([]limiter.QPSLimiter{l}, limit, 4*limit, duration), time.Second-windowSlack)
+			fmt.Printf("%v limiter - max rolling window=%v\n", algorithm, maxWindow)
+			require.LessOrEqualf(s.T(), maxWindow, limit, "no rolling window should exceed limit")
+		})
+	}
+}
+
+func (s *Suite) TestTokenBucket() {
+	const (
+		duration = 3 * time.Second
+		burst    = limit
+	)
+	urlRepo := mocks.NewMockRepo(s.urls, feedID, limit).WithAlgorithm(limiter.AlgorithmTokenBucket).WithBurst(burst)
+	fanOuter := fanouter.NewFanoutInteractor(urlRepo, controllers.NewHTTPClientFabric(), limiter.NewDefaultFabrics(), mocks.NewMockLogger(), mocks.NewMockMetrics())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(s.T(), fanOuter.Init(ctx))
+
+	// full bucket: burst goes out of limiter at once, it is checked on output of limiter as senders
+	// deliver it to servers at speed of the machine
+	tb, err := limiter.NewTBLimiterFabric().NewQPSLimiter(limiter.Config{FeedID: feedID, Burst: burst, Metrics: mocks.NewMockMetrics()})
+	require.Nil(s.T(), err)
+	out := make(chan entity.Message, burst)
+	tb.Init(out, limit)
+	go tb.DoLimiting(ctx)
+	for i := 0; i < burst; i++ {
+		require.Nil(s.T(), tb.Push(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)}))
+	}
+	burstQueries := 0
+	deadline := time.After(4 * windowSlack)
+burst_loop:
+	for burstQueries < burst {
+		select {
+		case <-out:
+			burstQueries++
+		case <-deadline:
+			break burst_loop
+		}
+	}
+	fmt.Printf("token bucket limiter - burst queries=%v\n", burstQueries)
+	require.Equal(s.T(), burst, burstQueries, "burst should be sent immediately")
+
+	for i := 0; i < burst; i++ {
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+		require.Nil(s.T(), err)
+	}
+	start := time.Now()
+	transmitQueryTicker := time.NewTicker(time.Second / (4 * limit)) // 4 times more then limit
+	stop := time.After(duration)
+send_loop:
+	for {
+		select {
+		case <-stop:
+			break send_loop
+		case <-transmitQueryTicker.C:
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+			require.Nil(s.T(), err)
+		}
+	}
+	transmitQueryTicker.Stop()
+	cancel()
+	for i, serverch := range s.servers {
+		serverch.mu.Lock()
+		hits := append([]time.Time(nil), serverch.hits...)
+		serverch.mu.Unlock()
+		steadyQueries := 0
+		for _, hit := range hits {
+			if since := hit.Sub(start); since >= time.Second && since < duration {
+				steadyQueries++
+			}
+		}
+		steady := (duration - time.Second).Seconds() * limit
+		fmt.Printf("token bucket server #%v - queries in last %v=%v\n", i, duration-time.Second, steadyQueries)
+		require.GreaterOrEqualf(s.T(), float64(steadyQueries), steady*0.9, "long-run rate should converge to limit")
+		require.LessOrEqualf(s.T(), float64(steadyQueries), steady*1.05, "long-run rate should not exceed limit")
+	}
+	for _, serverch := range s.servers {
+		serverch.ClearLimit()
+	}
+}
+
+func (s *Suite) TestDistributed() {
+	const (
+		duration = 3 * time.Second
+		replicas = 2
+	)
+	redis, err := mocks.NewFakeRedis()
+	require.Nil(s.T(), err)
+	defer redis.Close()
+	stores := map[string]limiter.Store{
+		"shared store":   repository.NewRedisStore(redis.Addr(), "", 0),
+		"replicas share": nil,
+	}
+	for name, store := range stores {
+		s.Run(name, func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			fanOuters := []*fanouter.FanoutInteractor{}
+			for i := 0; i < replicas; i++ {
+				urlRepo := mocks.NewMockRepo(s.urls, feedID, limit).WithAlgorithm(limiter.AlgorithmDistributed)
+				fabrics := limiter.NewDefaultFabrics().Register(limiter.AlgorithmDistributed, limiter.NewDistLimiterFabric(store, replicas))
+				fanOuter := fanouter.NewFanoutInteractor(urlRepo, controllers.NewHTTPClientFabric(), fabrics, mocks.NewMockLogger(), mocks.NewMockMetrics())
+				require.Nil(s.T(), fanOuter.Init(ctx))
+				fanOuters = append(fanOuters, fanOuter)
+			}
+
+			transmitQueryTicker := time.NewTicker(time.Second / (2 * limit)) // every replica gets 2 times more then limit
+			stop := time.After(duration)
+		send_loop:
+			for {
+				select {
+				case <-stop:
+					break send_loop
+				case <-transmitQueryTicker.C:
+					for _, fanOuter := range fanOuters {
+						_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+						require.Nil(s.T(), err)
+					}
+				}
+			}
+			transmitQueryTicker.Stop()
+			cancel()
+			for i, serverch := range s.servers {
+				receivedQueries := serverch.GetLimit()
+				fmt.Printf("%v server #%v - incoming request count=%v\n", name, i, receivedQueries)
+				require.LessOrEqualf(s.T(), float64(receivedQueries), float64(limit)*(duration.Seconds()+1), "replicas together should not exceed limit")
+				require.GreaterOrEqualf(s.T(), float64(receivedQueries), float64(limit)*duration.Seconds()*0.9, "90% (qps limit*duration) requests should be received by server")
+			}
+			time.Sleep(windowSlack) // let queries sent before cancel reach servers
+			for _, serverch := range s.servers {
+				serverch.ClearLimit()
+			}
+
+			var limiters []limiter.QPSLimiter
+			for i := 0; i < replicas; i++ {
+				l, err := limiter.NewDistLimiterFabric(store, replicas).NewQPSLimiter(limiter.Config{URLID: "limiters", FeedID: feedID, Metrics: mocks.NewMockMetrics()})
+				require.Nil(s.T(), err)
+				limiters = append(limiters, l)
+			}
+			maxWindow := maxRollingWindow(limiterHits(limiters, limit, 2*limit, duration), time.Second-windowSlack)
+			fmt.Printf("%v limiters - max rolling window=%v\n", name, maxWindow)
+			require.LessOrEqualf(s.T(), maxWindow, limit, "no rolling window of replicas together should exceed limit")
+		})
+	}
+}
+
+func (s *Suite) TestSQLRepo() {
+	const duration = 2 * time.Second
+	dir, err := ioutil.TempDir("", "fanouter")
+	require.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "params.db"))
+	require.Nil(s.T(), err)
+	defer db.Close()
+	repo := repository.NewSQLRepo(db)
+	require.Nil(s.T(), repo.Migrate())
+	require.Nil(s.T(), repo.Migrate(), "migrated schema should stay untouched")
+
+	// parameters are managed by admin tool
+	_, err = db.Exec(`INSERT INTO settings (name, value) VALUES ('timeout', '10'), ('poolsize', '5')`)
+	require.Nil(s.T(), err)
+	for i, url := range s.urls {
+		_, err = db.Exec(`INSERT INTO urls (id, position, value) VALUES (?, ?, ?)`, strconv.Itoa(i), i, url)
+		require.Nil(s.T(), err)
+		_, err = db.Exec(`INSERT INTO feeds (url_id, feed_id, qps_limit, options) VALUES (?, ?, ?, ?)`,
+			strconv.Itoa(i), feedID, strconv.Itoa(limit), `{"algorithm": "gcra"}`)
+		require.Nil(s.T(), err)
+	}
+	params, err := repo.Load()
+	require.Nil(s.T(), err)
+	expected, err := mocks.NewMockRepo(s.urls, feedID, limit).WithAlgorithm(limiter.AlgorithmGCRA).Load()
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), expected, params)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fanOuter := fanouter.NewFanoutInteractor(repo, controllers.NewHTTPClientFabric(), limiter.NewDefaultFabrics(), mocks.NewMockLogger(), mocks.NewMockMetrics())
+	require.Nil(s.T(), fanOuter.Init(ctx))
+	transmitQueryTicker := time.NewTicker(time.Second / (2 * limit))
+	stop := time.After(duration)
+send_loop:
+	for {
+		select {
+		case <-stop:
+			break send_loop
+		case <-transmitQueryTicker.C:
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+			require.Nil(s.T(), err)
+		}
+	}
+	transmitQueryTicker.Stop()
+	cancel()
+	for i, serverch := range s.servers {
+		receivedQueries := serverch.GetLimit()
+		fmt.Printf("sql repo server #%v - incoming request count=%v\n", i, receivedQueries)
+		require.LessOrEqualf(s.T(), float64(receivedQueries), float64(limit)*(duration.Seconds()+1), "limit from database should not be exceeded")
+		require.GreaterOrEqualf(s.T(), float64(receivedQueries), float64(limit)*duration.Seconds()*0.9, "90% (qps limit*duration) requests should be received by server")
+	}
+	time.Sleep(windowSlack) // let queries sent before cancel reach servers
+}
@@ -0,0 +1,121 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	controllers "github.com/shipa988/fanouter/internal/data/controller"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+// Target is external url counting received queries by body, queries of feed have its id in body.
+type Target struct {
+	*httptest.Server
+	mu    sync.Mutex
+	hits  map[string][]time.Time
+	reply func(body string, n int) int // status code of n-th query with body (from 1), 200 if nil
+}
+
+func NewTarget(reply func(body string, n int) int) *Target {
+	t := &Target{hits: make(map[string][]time.Time), reply: reply}
+	t.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body := string(b)
+		t.mu.Lock()
+		t.hits[body] = append(t.hits[body], time.Now())
+		n := len(t.hits[body])
+		t.mu.Unlock()
+		if t.reply != nil {
+			w.WriteHeader(t.reply(body, n))
+		}
+	}))
+	return t
+}
+
+// Count returns count of received queries with body.
+func (t *Target) Count(body string) int {
+	return t.CountSince(body, time.Time{})
+}
+
+// CountSince returns count of queries with body received since time.
+func (t *Target) CountSince(body string, since time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, hit := range t.hits[body] {
+		if !hit.Before(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// Hits returns times of received queries with body.
+func (t *Target) Hits(body string) []time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]time.Time(nil), t.hits[body]...)
+}
+
+// newFanouter returns fanouter of repo with all limiting algorithms.
+func newFanouter(repo entity.FanParamRepo) *fanouter.FanoutInteractor {
+	return fanouter.NewFanoutInteractor(repo, controllers.NewHTTPClientFabric(), limiter.NewDefaultFabrics(), mocks.NewMockLogger(), mocks.NewMockMetrics())
+}
+
+// drive fanouts queries of feeds (body is feed id) with qps for each feed during duration, returns errors of fanout.
+func drive(ctx context.Context, f fanouter.Fanouter, qps int, duration time.Duration, feedIDs ...string) []error {
+	var errs []error
+	ticker := time.NewTicker(time.Second / time.Duration(qps))
+	defer ticker.Stop()
+	stop := time.After(duration)
+	for {
+		select {
+		case <-stop:
+			return errs
+		case <-ctx.Done():
+			return errs
+		case <-ticker.C:
+			for _, id := range feedIDs {
+				if _, err := f.Fanout(ctx, entity.Message{FeedID: id, Body: []byte(id)}); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+}
+
+// serve starts http server of fanouter on free port, returns its address and function stopping it.
+func serve(t *testing.T, f fanouter.Fanouter) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := l.Addr().String()
+	require.Nil(t, l.Close())
+	server := controllers.NewHttpServer(addr, mocks.NewMockLogger(), f, http.NotFoundHandler())
+	go server.Serve() //nolint:errcheck
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "http://" + addr, server.StopServe
+}
+
+// params returns fanout parameters of urls.
+func params(urls ...entity.URL) *entity.FanParam {
+	return &entity.FanParam{TimeOut: 10, PoolSize: 5, URLs: urls}
+}
@@ -0,0 +1,63 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestIdempotentPartial(t *testing.T) {
+	accepting, rejecting := NewTarget(nil), NewTarget(nil)
+	defer accepting.Close()
+	defer rejecting.Close()
+	interactor := newFanouter(mocks.NewStaticRepo(params(
+		entity.URL{ID: "accepting", Value: accepting.URL, Feeds: []entity.Feed{{ID: feedID, Limit: "100"}}},
+		entity.URL{ID: "rejecting", Value: rejecting.URL, Feeds: []entity.Feed{{ID: feedID, Limit: "2", Overflow: limiter.OverflowReject}}},
+	)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, interactor.Init(ctx))
+	fanOuter := fanouter.NewIdempotentFanouter(interactor, "", time.Minute)
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ { // fill queue of rejecting url
+		_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte("fill")})
+	}
+	require.NotNil(t, err)
+
+	keyed := entity.Message{FeedID: feedID, Header: http.Header{fanouter.HeaderIdempotencyKey: {"key"}}, Body: []byte("keyed")}
+	_, err = fanOuter.Fanout(ctx, keyed)
+	partial, ok := err.(*fanouter.PartialError)
+	require.Truef(t, ok, "query accepted by one url only should fail with *fanouter.PartialError, got %v", err)
+	require.Equal(t, []string{"accepting"}, partial.Accepted)
+	require.Equal(t, []string{"rejecting"}, partial.Rejected)
+	_, ok = errors.Cause(err).(*limiter.OverflowError)
+	require.True(t, ok, "cause of partial rejection should be answered 429")
+
+	require.Eventually(t, func() bool { // queue of rejecting url is drained
+		_, err := interactor.Fanout(ctx, entity.Message{FeedID: feedID, URLIDs: []string{"rejecting"}, Body: []byte("probe")})
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := fanOuter.Fanout(ctx, keyed) // repeat is fanned out to rejecting url only
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	_, err = fanOuter.Fanout(ctx, keyed) // accepted by all urls: outcome is returned
+	require.Nil(t, err)
+
+	require.Eventually(t, func() bool { return rejecting.Count("keyed") == 1 }, 5*time.Second, 50*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, 1, accepting.Count("keyed"), "accepting url should receive query once")
+	require.Equal(t, 1, rejecting.Count("keyed"), "rejecting url should receive query once")
+}
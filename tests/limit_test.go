@@ -0,0 +1,73 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+// burst fanouts count queries of feed at once every second during duration.
+func burst(ctx context.Context, t *testing.T, f fanouter.Fanouter, feedID string, count int, duration time.Duration) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	stop := time.After(duration)
+	for {
+		for i := 0; i < count; i++ {
+			_, err := f.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+			require.Nil(t, err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	const (
+		before = 10
+		after  = 40
+	)
+	target := NewTarget(nil)
+	defer target.Close()
+	repo := mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{
+		{ID: feedID, Limit: fmt.Sprint(before)},
+	}}))
+	fanOuter := newFanouter(repo)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+	addr, stop := serve(t, fanOuter)
+	defer stop()
+
+	burst(ctx, t, fanOuter, feedID, before, 2*time.Second)
+
+	req, err := http.NewRequest(http.MethodPut, addr+"/admin/urls/u/feeds/"+feedID+"/limit", strings.NewReader(fmt.Sprintf(`{"limit":%d}`, after)))
+	require.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	changed := time.Now()
+	burst(ctx, t, fanOuter, feedID, after, 3*time.Second) // bursts of new limit fit in queue grown with limit
+	time.Sleep(time.Second)
+	cancel()
+
+	received := target.CountSince(feedID, changed.Add(time.Second)) - target.CountSince(feedID, changed.Add(3*time.Second))
+	fmt.Printf("queries received in 2s after limit changed to %v: %v\n", after, received)
+	require.GreaterOrEqualf(t, float64(received), 2*after*0.85, "qps should follow changed limit")
+	require.LessOrEqualf(t, float64(received), 2*after*1.1, "qps should not exceed changed limit")
+}
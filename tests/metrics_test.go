@@ -0,0 +1,77 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	controllers "github.com/shipa988/fanouter/internal/data/controller"
+	"github.com/shipa988/fanouter/internal/data/metrics/prommetrics"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestMetrics(t *testing.T) {
+	target := NewTarget(func(body string, n int) int { return http.StatusTooManyRequests })
+	defer target.Close()
+	before := params(
+		entity.URL{ID: "u", Value: target.URL, Adaptive: &entity.Adaptive{Decrease: 0.5, Min: 0.5, Interval: 100},
+			Quota: &entity.Quota{Limit: 100, Period: entity.QuotaDay}, Feeds: []entity.Feed{
+				{ID: "kept", Limit: "10"},
+				{ID: "removed", Limit: "10"},
+			}},
+		entity.URL{ID: "gone", Value: target.URL, Feeds: []entity.Feed{{ID: "kept", Limit: "10"}}},
+	)
+	repo := mocks.NewStaticRepo(before)
+	metrics := prommetrics.NewMetrics()
+	fanOuter := fanouter.NewFanoutInteractor(repo, controllers.NewHTTPClientFabric(), limiter.NewDefaultFabrics(), mocks.NewMockLogger(), metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+	scrape := func() string {
+		resp, err := http.Get(server.URL)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return string(b)
+	}
+
+	drive(ctx, fanOuter, 20, time.Second, "kept", "removed")
+	rate, err := fanOuter.Rate(ctx, "u")
+	require.Nil(t, err)
+	require.Equal(t, 5, rate.Feeds[0].Effective, "rate of throttled url should be decreased")
+	text := scrape()
+	for _, series := range []string{
+		`fanouter_limiter_configured_qps{feed="kept",url="u"} 10`,
+		`fanouter_limiter_configured_qps{feed="removed",url="u"} 10`,
+		`fanouter_limiter_quota_remaining{feed="",url="u"}`,
+		`fanouter_sender_requests_total{code="4xx",feed="removed",url="u"}`,
+		`fanouter_sender_breaker_state{url="gone"} 0`,
+		`fanouter_limiter_sent_total{feed="kept",url="gone"}`,
+	} {
+		require.Contains(t, text, series, "configured limit should not be adapted, quota should be limiter metric")
+	}
+
+	after := params(before.URLs[0])
+	after.URLs[0].Feeds = after.URLs[0].Feeds[:1]
+	repo.Set(after)
+	require.Nil(t, fanOuter.Reload(ctx))
+	require.Eventually(t, func() bool { // after queued queries of removed url and feed are sent
+		text = scrape()
+		return !strings.Contains(text, `url="gone"`) && !strings.Contains(text, `feed="removed"`)
+	}, 5*time.Second, 100*time.Millisecond, "series of removed url and feed should be deleted")
+	require.Contains(t, text, `fanouter_limiter_configured_qps{feed="kept",url="u"} 10`, "series of kept feed should stay")
+}
@@ -0,0 +1,154 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestOverflow(t *testing.T) {
+	target := NewTarget(nil)
+	defer target.Close()
+	start := func(t *testing.T, feed entity.Feed) (*fanouter.FanoutInteractor, context.Context, context.CancelFunc) {
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{feed}})))
+		ctx, cancel := context.WithCancel(context.Background())
+		require.Nil(t, fanOuter.Init(ctx))
+		return fanOuter, ctx, cancel
+	}
+
+	t.Run(limiter.OverflowDropOldest, func(t *testing.T) {
+		const queries = 20
+		fanOuter, ctx, cancel := start(t, entity.Feed{ID: "oldest", Limit: "5", Overflow: limiter.OverflowDropOldest})
+		defer cancel()
+		for i := 0; i < queries; i++ {
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "oldest", Body: []byte("oldest-" + strconv.Itoa(i))})
+			require.Nil(t, err)
+		}
+		time.Sleep(1500 * time.Millisecond)
+		require.Equal(t, 1, target.Count("oldest-"+strconv.Itoa(queries-1)), "the newest query should be kept")
+		received := 0
+		for i := 0; i < queries; i++ {
+			received += target.Count("oldest-" + strconv.Itoa(i))
+		}
+		require.LessOrEqual(t, received, 5+1, "only queue of limit size and query taken by limiter should be sent")
+	})
+
+	t.Run(limiter.OverflowBlock, func(t *testing.T) {
+		const timeout = 100 * time.Millisecond
+		fanOuter, ctx, cancel := start(t, entity.Feed{ID: "block", Limit: "1", Overflow: limiter.OverflowBlock, BlockTimeout: int(timeout / time.Millisecond)})
+		defer cancel()
+		var last time.Duration
+		for i := 0; i < 4; i++ { // query taken by limiter and full queue of one query: the rest wait
+			begin := time.Now()
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "block", Body: []byte("block")})
+			require.Nil(t, err)
+			last = time.Since(begin)
+		}
+		require.GreaterOrEqual(t, int64(last), int64(timeout), "fanout should wait for free place in queue up to blocktimeout")
+		require.Less(t, int64(last), int64(5*timeout), "fanout should not wait longer than blocktimeout")
+
+		canceled, stop := context.WithCancel(ctx)
+		stop()
+		_, err := fanOuter.Fanout(canceled, entity.Message{FeedID: "block", Body: []byte("block")})
+		require.Equal(t, context.Canceled, err, "waiting should be stopped by canceled query")
+	})
+
+	t.Run(limiter.OverflowBlock+" while queue changes", func(t *testing.T) {
+		const timeout = 5 * time.Second
+		feed := entity.Feed{ID: "blocked", Limit: "1", Overflow: limiter.OverflowBlock, BlockTimeout: int(timeout / time.Millisecond)}
+		repo := mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{feed}}))
+		fanOuter := newFanouter(repo)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+		fanout := func() <-chan error {
+			done := make(chan error, 1)
+			go func() {
+				_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "blocked", Body: []byte("blocked")})
+				done <- err
+			}()
+			return done
+		}
+		waiting := func(done <-chan error) {
+			select {
+			case err := <-done:
+				t.Fatalf("query should wait for free place in queue, got %v", err)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		finished := func(done <-chan error) error {
+			select {
+			case err := <-done:
+				return err
+			case <-time.After(timeout / 5):
+				t.Fatal("query should not wait for blocktimeout")
+				return nil
+			}
+		}
+		for i := 0; i < 2; i++ { // query taken by limiter and full queue of one query
+			require.Nil(t, <-fanout())
+		}
+		blocked := fanout()
+		waiting(blocked)
+
+		require.Nil(t, fanOuter.SetLimit(ctx, "u", "blocked", 10))
+		require.Nil(t, finished(fanout()), "queue should grow while query waits")
+		require.Nil(t, finished(blocked), "waiting query should be queued to grown queue")
+
+		require.Nil(t, fanOuter.SetLimit(ctx, "u", "blocked", 1)) // grown queue is not shrunk: it is filled at once
+	fill:
+		for i := 0; i < 20; i++ {
+			blocked = fanout()
+			select {
+			case err := <-blocked:
+				require.Nil(t, err)
+			case <-time.After(100 * time.Millisecond):
+				break fill
+			}
+		}
+		waiting(blocked)
+		repo.Set(params(entity.URL{ID: "u", Value: target.URL}))
+		begin := time.Now()
+		require.Nil(t, fanOuter.Reload(ctx))
+		require.Less(t, int64(time.Since(begin)), int64(timeout/5), "queue should be closed while query waits")
+		require.Nil(t, finished(blocked), "query waiting for closed queue should be skipped as query of removed feed")
+	})
+
+	t.Run(limiter.OverflowReject, func(t *testing.T) {
+		fanOuter, ctx, cancel := start(t, entity.Feed{ID: "reject", Limit: "1", Overflow: limiter.OverflowReject})
+		defer cancel()
+		var err error
+		for i := 0; i < 4 && err == nil; i++ {
+			_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: "reject", Body: []byte("reject")})
+		}
+		overflow, ok := errors.Cause(err).(*limiter.OverflowError)
+		require.Truef(t, ok, "full queue should reject query with *limiter.OverflowError, got %v", err)
+		require.Greater(t, int64(overflow.RetryAfter), int64(0))
+
+		addr, stopServe := serve(t, fanOuter)
+		defer stopServe()
+		var resp *http.Response
+		for i := 0; i < 4 && (resp == nil || resp.StatusCode == http.StatusOK); i++ { // queue may be freed by limiter taking query
+			resp, err = http.Post(addr+"/feeds/reject", "text/plain", strings.NewReader("reject"))
+			require.Nil(t, err)
+			resp.Body.Close()
+		}
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		retry, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		require.Nil(t, err)
+		require.GreaterOrEqual(t, retry, 1)
+	})
+}
@@ -0,0 +1,66 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestPriority(t *testing.T) {
+	target := NewTarget(nil)
+	defer target.Close()
+	fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{
+		{ID: "prioritized", Limit: "10", Priority: entity.PriorityLow, Starvation: 3},
+	}})))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+
+	fanout := func(body string, query url.Values, header http.Header) {
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "prioritized", Body: []byte(body), Query: query, Header: header})
+		require.Nil(t, err)
+	}
+	fanout("first", nil, nil) // taken by limiter before the rest are queued
+	time.Sleep(20 * time.Millisecond)
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		bodies = append(bodies, "low-"+strconv.Itoa(i))
+		fanout(bodies[len(bodies)-1], nil, nil) // class of feed
+	}
+	bodies = append(bodies, "normal")
+	fanout("normal", url.Values{fanouter.ParamPriority: {entity.PriorityNormal}}, nil)
+	for i := 0; i < 7; i++ {
+		bodies = append(bodies, "high-"+strconv.Itoa(i))
+		fanout(bodies[len(bodies)-1], nil, http.Header{fanouter.HeaderPriority: {entity.PriorityHigh}})
+	}
+	time.Sleep(2 * time.Second)
+
+	require.Equal(t, 1, target.Count("first"))
+	sent := make(map[string]time.Time)
+	for _, body := range bodies {
+		hits := target.Hits(body)
+		require.Lenf(t, hits, 1, "query %v should be sent once", body)
+		sent[body] = hits[0]
+	}
+	sort.Slice(bodies, func(i, j int) bool { return sent[bodies[i]].Before(sent[bodies[j]]) })
+	require.Equal(t, []string{
+		"high-0", "high-1", "high-2",
+		"low-0",  // waited for starvation queries of higher classes
+		"normal", // the same
+		"high-3", "high-4",
+		"low-1", // waited for starvation queries since previous one
+		"high-5", "high-6",
+	}, bodies, "higher classes should be sent first, lower ones should not starve")
+}
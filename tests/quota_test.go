@@ -0,0 +1,91 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestQuotaReject(t *testing.T) {
+	const quota = 5
+	dir, err := ioutil.TempDir("", "quota")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	quotas := repository.NewQuotaRepo(filepath.Join(dir, "quota.json"))
+
+	target := NewTarget(nil)
+	defer target.Close()
+	fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{
+		{ID: feedID, Limit: "10", Quota: &entity.Quota{Limit: quota, Period: entity.QuotaDay, Exhausted: entity.QuotaReject}},
+	}}))).WithQuotas(quotas)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+
+	for i := 0; i < quota; i++ { // queued faster than sent: quota is taken at once
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+		require.Nil(t, err)
+	}
+	_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: feedID, Body: []byte(feedID)})
+	quotaErr, ok := errors.Cause(err).(*limiter.QuotaError)
+	require.Truef(t, ok, "query over quota should be rejected with *limiter.QuotaError, got %v", err)
+	require.Greater(t, int64(quotaErr.RetryAfter), int64(0))
+
+	require.Eventually(t, func() bool { return target.Count(feedID) == quota }, 5*time.Second, 50*time.Millisecond,
+		"accepted queries should be sent without waiting for the next period")
+
+	cancel()
+	fanOuter.Wait()
+	usage, err := quotas.Load()
+	require.Nil(t, err)
+	require.Len(t, usage, 1)
+	require.Equal(t, int64(quota), usage[0].Used, "usage should be saved on stop")
+}
+
+func TestQuotaRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	quotas := repository.NewQuotaRepo(filepath.Join(dir, "quota.json"))
+
+	target := NewTarget(nil)
+	defer target.Close()
+	quota := &entity.Quota{Limit: 100, Period: entity.QuotaDay}
+	kept := entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{{ID: feedID, Limit: "10", Quota: quota}}}
+	repo := mocks.NewStaticRepo(params(
+		entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{{ID: feedID, Limit: "10", Quota: quota}, {ID: "removed", Limit: "10", Quota: quota}}},
+		entity.URL{ID: "gone", Value: target.URL, Quota: quota, Feeds: []entity.Feed{{ID: feedID, Limit: "10"}}},
+	))
+	fanOuter := newFanouter(repo).WithQuotas(quotas)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+
+	for _, id := range []string{feedID, "removed"} {
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: id, Body: []byte(id)})
+		require.Nil(t, err)
+	}
+	repo.Set(params(kept))
+	require.Nil(t, fanOuter.Reload(ctx))
+
+	cancel()
+	fanOuter.Wait()
+	usage, err := quotas.Load()
+	require.Nil(t, err)
+	require.Len(t, usage, 1, "quotas of removed url and feed should not be kept")
+	require.Equal(t, "u", usage[0].URLID)
+	require.Equal(t, feedID, usage[0].FeedID)
+}
@@ -0,0 +1,122 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestReload(t *testing.T) {
+	const (
+		queued  = 4  // queries of removed feed queued before reload, its limit and queue size
+		limit   = 20 // of unchanged feed
+		changed = 30 // limit of unchanged feed set by admin api
+	)
+	before := func(url string) *entity.FanParam {
+		return params(entity.URL{ID: "u", Value: url, Feeds: []entity.Feed{
+			{ID: "kept", Limit: strconv.Itoa(limit)},
+			{ID: "removed", Limit: strconv.Itoa(queued)},
+		}})
+	}
+
+	dir, err := ioutil.TempDir("", "urls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "urls.json")
+
+	tcases := []struct {
+		name   string
+		repo   func(url string) entity.FanParamRepo
+		remove func(repo entity.FanParamRepo, url string) // removes feed from stored parameters
+		stored int                                        // stored limit of unchanged feed after reload
+	}{
+		{
+			name: "read-only store",
+			repo: func(url string) entity.FanParamRepo { return mocks.NewStaticRepo(before(url)) },
+			remove: func(repo entity.FanParamRepo, url string) {
+				p := before(url)
+				p.URLs[0].Feeds = p.URLs[0].Feeds[:1]
+				repo.(*mocks.StaticRepo).Set(p)
+			},
+			stored: limit,
+		},
+		{
+			name: "writable store",
+			repo: func(url string) entity.FanParamRepo {
+				b, err := json.Marshal(before(url))
+				require.Nil(t, err)
+				require.Nil(t, ioutil.WriteFile(path, b, 0644))
+				return repository.NewJSONRepo(path)
+			},
+			remove: func(repo entity.FanParamRepo, url string) {
+				require.Nil(t, repo.(entity.FanParamWriter).Update(func(params *entity.FanParam) error {
+					params.URLs[0].Feeds = params.URLs[0].Feeds[:1]
+					return nil
+				}))
+			},
+			stored: changed,
+		},
+	}
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			target := NewTarget(nil)
+			defer target.Close()
+			repo := tcase.repo(target.URL)
+			fanOuter := newFanouter(repo)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			require.Nil(t, fanOuter.Init(ctx))
+			require.Nil(t, fanOuter.SetLimit(ctx, "u", "kept", changed))
+
+			start := time.Now()
+			errs := make(chan []error, 1)
+			go func() {
+				errs <- drive(ctx, fanOuter, changed, 2*time.Second, "kept")
+			}()
+			time.Sleep(time.Second)
+			for i := 0; i < queued; i++ {
+				_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "removed", Body: []byte("removed-" + strconv.Itoa(i))})
+				require.Nil(t, err)
+			}
+			tcase.remove(repo, target.URL)
+			require.Nil(t, fanOuter.Reload(ctx))
+			require.Empty(t, <-errs)
+
+			hits := target.Hits("kept")
+			require.NotEmpty(t, hits)
+			for i := 1; i < len(hits); i++ {
+				require.Lessf(t, int64(hits[i].Sub(hits[i-1])), int64(5*time.Second/changed), "unchanged feed should keep sending during reload (after %v)", hits[i-1].Sub(start))
+			}
+
+			rate, err := fanOuter.Rate(ctx, "u")
+			require.Nil(t, err)
+			require.Equal(t, []fanouter.FeedRate{{ID: "kept", Profile: fanouter.DefaultProfile, Limit: changed, Effective: changed}}, rate.Feeds,
+				"limit set by admin api should survive reload, removed feed should be stopped")
+
+			stored, err := repo.Load()
+			require.Nil(t, err)
+			require.Equal(t, strconv.Itoa(tcase.stored), stored.URLs[0].Feeds[0].Limit)
+
+			time.Sleep(1500 * time.Millisecond)
+			for i := 0; i < queued; i++ {
+				require.Equalf(t, 1, target.Count("removed-"+strconv.Itoa(i)), "queued query %v of removed feed should be sent", i)
+			}
+			_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: "removed", Body: []byte("removed")})
+			require.Equal(t, fanouter.ErrNotFound, err, "removed feed should not accept queries")
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// +build integration
+
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	now := time.Date(2020, time.October, 12, 9, 0, 0, 0, time.UTC)
+	msg := entity.Message{
+		FeedID:    "f",
+		RequestID: "r1",
+		Query:     url.Values{"q": {"shoes"}, "page": {"2"}},
+		Header:    http.Header{"X-User": {"42"}, "X-Secret": {"s"}},
+		Body:      []byte("payload"),
+	}
+	tcases := []struct {
+		name string
+		url  entity.URL
+		msg  entity.Message
+		want sender.Request
+	}{
+		{
+			name: "default method",
+			url:  entity.URL{ID: "u", Value: "http://localhost/hook"},
+			msg:  entity.Message{FeedID: "f", Body: []byte("payload")},
+			want: sender.Request{Method: http.MethodGet, URL: "http://localhost/hook", Header: map[string][]string{}, Body: []byte("payload")},
+		},
+		{
+			name: "method of incoming query",
+			url:  entity.URL{ID: "u", Value: "http://localhost/hook"},
+			msg:  entity.Message{FeedID: "f", Method: http.MethodPut},
+			want: sender.Request{Method: http.MethodPut, URL: "http://localhost/hook", Header: map[string][]string{}},
+		},
+		{
+			name: "all params forwarded",
+			url:  entity.URL{ID: "u", Value: "http://localhost/hook?src=fanouter"},
+			msg:  msg,
+			want: sender.Request{Method: http.MethodGet, URL: "http://localhost/hook?page=2&q=shoes&src=fanouter",
+				Header: map[string][]string{sender.HeaderRequestID: {"r1"}}, Body: []byte("payload")},
+		},
+		{
+			name: "param map",
+			url: entity.URL{ID: "u", Value: "http://localhost/hook", Params: &entity.ParamMap{
+				Query: map[string]string{"q": "query", "missing": "other"}, Headers: []string{"x-user"}, Body: true}},
+			msg: msg,
+			want: sender.Request{Method: http.MethodGet, URL: "http://localhost/hook?query=shoes",
+				Header: map[string][]string{"X-User": {"42"}, sender.HeaderRequestID: {"r1"}}, Body: []byte("payload")},
+		},
+		{
+			name: "param map without body",
+			url:  entity.URL{ID: "u", Value: "http://localhost/hook", Params: &entity.ParamMap{}},
+			msg:  msg,
+			want: sender.Request{Method: http.MethodGet, URL: "http://localhost/hook",
+				Header: map[string][]string{sender.HeaderRequestID: {"r1"}}},
+		},
+		{
+			name: "static headers",
+			url: entity.URL{ID: "u", Value: "http://localhost/hook", Params: &entity.ParamMap{Headers: []string{"X-User"}},
+				Headers: map[string]string{"x-user": "static", "Authorization": "Bearer t"}},
+			msg: msg,
+			want: sender.Request{Method: http.MethodGet, URL: "http://localhost/hook",
+				Header: map[string][]string{"X-User": {"static"}, "Authorization": {"Bearer t"}, sender.HeaderRequestID: {"r1"}}},
+		},
+		{
+			name: "templates",
+			url: entity.URL{ID: "u", Value: "http://localhost/feeds/{{.FeedID}}?user={{.Header.Get \"X-User\"}}",
+				Body: "{{.RequestID}} {{.Query.Get \"q\"}} {{.Query.Get \"absent\"}}{{.Body}} {{.Time.Unix}}"},
+			msg: msg,
+			want: sender.Request{Method: http.MethodGet, URL: "http://localhost/feeds/f?page=2&q=shoes&user=42",
+				Header: map[string][]string{sender.HeaderRequestID: {"r1"}}, Body: []byte("r1 shoes payload 1602493200")},
+		},
+		{
+			name: "post json of params",
+			url: entity.URL{ID: "u", Value: "http://localhost/hook", Method: http.MethodPost, Params: &entity.ParamMap{},
+				Headers: map[string]string{"Content-Type": "application/json"},
+				Body:    `{"feed": {{json .FeedID}}, "q": {{json (.Query.Get "q")}}, "query": {{json .Query}}}`},
+			msg: msg,
+			want: sender.Request{Method: http.MethodPost, URL: "http://localhost/hook",
+				Header: map[string][]string{"Content-Type": {"application/json"}, sender.HeaderRequestID: {"r1"}},
+				Body:   []byte(`{"feed": "f", "q": "shoes", "query": {"page":["2"],"q":["shoes"]}}`)},
+		},
+		{
+			name: "method of url overrides incoming one",
+			url:  entity.URL{ID: "u", Value: "http://localhost/hook", Method: http.MethodDelete},
+			msg:  entity.Message{FeedID: "f", Method: http.MethodPost, Query: url.Values{"id": {"7"}}},
+			want: sender.Request{Method: http.MethodDelete, URL: "http://localhost/hook?id=7", Header: map[string][]string{}},
+		},
+		{
+			name: "put forwarded body",
+			url:  entity.URL{ID: "u", Value: "http://localhost/hook", Method: http.MethodPut, Params: &entity.ParamMap{Body: true}},
+			msg:  msg,
+			want: sender.Request{Method: http.MethodPut, URL: "http://localhost/hook",
+				Header: map[string][]string{sender.HeaderRequestID: {"r1"}}, Body: []byte("payload")},
+		},
+	}
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			builder, err := sender.NewRequestBuilder(tcase.url)
+			require.Nil(t, err)
+			req, err := builder.Build(tcase.msg, now)
+			require.Nil(t, err)
+			require.Equal(t, tcase.want, req)
+		})
+	}
+
+	t.Run("missing param", func(t *testing.T) {
+		builder, err := sender.NewRequestBuilder(entity.URL{ID: "u", Value: "http://localhost/hook", Body: "{{index .Query.q 0}}"})
+		require.Nil(t, err)
+		req, err := builder.Build(msg, now)
+		require.Nil(t, err)
+		require.Equal(t, []byte("shoes"), req.Body)
+		_, err = builder.Build(entity.Message{FeedID: "f", Query: url.Values{"page": {"2"}}}, now)
+		require.NotNil(t, err, "request should not be built without param required by template")
+		require.Contains(t, err.Error(), "can't execute body template of url u")
+	})
+
+	t.Run("bad template", func(t *testing.T) {
+		_, err := sender.NewRequestBuilder(entity.URL{ID: "u", Value: "http://localhost/{{.FeedID"})
+		require.NotNil(t, err)
+	})
+}
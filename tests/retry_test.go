@@ -0,0 +1,203 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/sender"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestRetry(t *testing.T) {
+	const base = 200 * time.Millisecond
+
+	t.Run("exponential backoff", func(t *testing.T) {
+		target := NewTarget(func(body string, n int) int {
+			if n < 3 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusOK
+		})
+		defer target.Close()
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+			Retry: &entity.Retry{Attempts: 3, Base: int(base / time.Millisecond)}, Feeds: []entity.Feed{{ID: "retry", Limit: "100"}}})))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "retry", Body: []byte("retry")})
+		require.Nil(t, err)
+		time.Sleep(4 * base)
+		hits := target.Hits("retry")
+		require.Len(t, hits, 3, "failed query should be retried until it succeeds")
+		require.GreaterOrEqual(t, int64(hits[1].Sub(hits[0])), int64(base), "the first retry should wait base backoff")
+		require.GreaterOrEqual(t, int64(hits[2].Sub(hits[1])), int64(2*base), "backoff should be doubled for the next retry")
+		require.Less(t, int64(hits[2].Sub(hits[1])), int64(3*base))
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		var (
+			mu   sync.Mutex
+			hits []time.Time
+		)
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			hits = append(hits, time.Now())
+			if len(hits) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		defer target.Close()
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+			Retry: &entity.Retry{Attempts: 2, Base: 10}, Feeds: []entity.Feed{{ID: "retry", Limit: "100"}}})))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+
+		_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "retry", Body: []byte("retry")})
+		require.Nil(t, err)
+		time.Sleep(1500 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, hits, 2)
+		require.GreaterOrEqual(t, int64(hits[1].Sub(hits[0])), int64(time.Second), "retry should wait as long as Retry-After asks")
+	})
+
+	t.Run("retries are limited", func(t *testing.T) {
+		const (
+			limit   = 5
+			queries = 5 // each is sent twice
+		)
+		target := NewTarget(func(body string, n int) int {
+			if n < 2 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusOK
+		})
+		defer target.Close()
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+			Retry: &entity.Retry{Attempts: 2, Base: 10}, Feeds: []entity.Feed{{ID: "retry", Limit: strconv.Itoa(limit)}}})))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+
+		begin := time.Now()
+		for i := 0; i < queries; i++ {
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "retry", Body: []byte("retry-" + strconv.Itoa(i))})
+			require.Nil(t, err)
+		}
+		time.Sleep(2500 * time.Millisecond)
+		var sent []time.Time
+		for i := 0; i < queries; i++ {
+			hits := target.Hits("retry-" + strconv.Itoa(i))
+			require.Lenf(t, hits, 2, "query %v should be retried once", i)
+			sent = append(sent, hits...)
+		}
+		inFirstSecond := 0
+		for _, hit := range sent {
+			if hit.Sub(begin) < time.Second {
+				inFirstSecond++
+			}
+		}
+		require.LessOrEqual(t, inFirstSecond, limit+1, "retries should pass limiter of feed")
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		target := NewTarget(func(body string, n int) int { return http.StatusServiceUnavailable })
+		defer target.Close()
+		dir, err := ioutil.TempDir("", "dlq")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		letters := repository.NewDLQRepo(filepath.Join(dir, "dlq.json"))
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+			Retry: &entity.Retry{Attempts: 3, Base: 10}, Feeds: []entity.Feed{{ID: "retry", Limit: "100"}}})))
+		fanOuter.WithDeadLetters(letters)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+
+		_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: "retry", Body: []byte("retry")})
+		require.Nil(t, err)
+		time.Sleep(500 * time.Millisecond)
+		require.Equal(t, 3, target.Count("retry"), "query should be sent attempts times")
+		list, err := letters.List()
+		require.Nil(t, err)
+		require.Len(t, list, 1, "query failed all attempts should become dead letter")
+		require.Equal(t, "u", list[0].URLID)
+		require.Equal(t, "retry", list[0].FeedID)
+		require.Equal(t, 3, list[0].Attempts)
+	})
+	t.Run("stopped retry", func(t *testing.T) {
+		target := NewTarget(func(body string, n int) int { return http.StatusServiceUnavailable })
+		defer target.Close()
+		dir, err := ioutil.TempDir("", "dlq")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		letters := repository.NewDLQRepo(filepath.Join(dir, "dlq.json"))
+		u := entity.URL{ID: "u", Value: target.URL, Retry: &entity.Retry{Attempts: 3, Base: int(base / time.Millisecond)},
+			Feeds: []entity.Feed{{ID: "retry", Limit: "100"}}}
+		repo := mocks.NewStaticRepo(params(u))
+		fanOuter := newFanouter(repo)
+		fanOuter.WithDeadLetters(letters)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+
+		_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: "retry", Body: []byte("retry")})
+		require.Nil(t, err)
+		time.Sleep(base / 2)
+		u.Value += "/changed"
+		repo.Set(params(u))
+		require.Nil(t, fanOuter.Reload(ctx)) // sender of url is restarted while retry waits
+		time.Sleep(2 * base)
+		require.Equal(t, 1, target.Count("retry"), "stopped sender should not retry")
+		list, err := letters.List()
+		require.Nil(t, err)
+		require.Len(t, list, 1, "query waiting for retry of stopped sender should become dead letter")
+		require.Equal(t, 1, list[0].Attempts)
+		require.Contains(t, list[0].Error, "503", "dead letter should keep the last error")
+	})
+
+	t.Run("open breaker", func(t *testing.T) {
+		target := NewTarget(func(body string, n int) int { return http.StatusInternalServerError })
+		defer target.Close()
+		dir, err := ioutil.TempDir("", "dlq")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		letters := repository.NewDLQRepo(filepath.Join(dir, "dlq.json"))
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL,
+			Retry:   &entity.Retry{Attempts: 3, Base: 10},
+			Breaker: &entity.Breaker{FailureRate: 0.5, MinRequests: 1, OpenTimeout: 5000},
+			Feeds:   []entity.Feed{{ID: "retry", Limit: "100"}}})))
+		fanOuter.WithDeadLetters(letters)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+
+		_, err = fanOuter.Fanout(ctx, entity.Message{FeedID: "retry", Body: []byte("retry")})
+		require.Nil(t, err)
+		time.Sleep(300 * time.Millisecond)
+		require.Equal(t, 1, target.Count("retry"), "the first failure opens breaker")
+		list, err := letters.List()
+		require.Nil(t, err)
+		require.Len(t, list, 1)
+		require.Equal(t, 2, list[0].Attempts, "short-circuited retry should not be retried again")
+		require.Equal(t, sender.ErrBreakerOpen.Error(), list[0].Error)
+	})
+}
@@ -0,0 +1,74 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestRouting(t *testing.T) {
+	const queries = 200 // of each kind
+	kinds := []struct {
+		body   string
+		query  url.Values
+		header http.Header
+	}{
+		{body: "ru-beta", query: url.Values{"country": {"ru"}}, header: http.Header{"X-Beta": {"on"}}},
+		{body: "ru", query: url.Values{"country": {"ru"}}},
+		{body: "us", query: url.Values{"country": {"us"}}, header: http.Header{"X-Beta": {"on"}}},
+	}
+	all, matched, sampled, heavy, light := NewTarget(nil), NewTarget(nil), NewTarget(nil), NewTarget(nil), NewTarget(nil)
+	for _, target := range []*Target{all, matched, sampled, heavy, light} {
+		defer target.Close()
+	}
+	feed := func(feed entity.Feed) []entity.Feed {
+		feed.ID, feed.Limit = "routed", "1000"
+		return []entity.Feed{feed}
+	}
+	fanOuter := newFanouter(mocks.NewStaticRepo(params(
+		entity.URL{ID: "all", Value: all.URL, Feeds: feed(entity.Feed{})},
+		entity.URL{ID: "matched", Value: matched.URL, Feeds: feed(entity.Feed{
+			Match: &entity.Match{Query: map[string]string{"country": "ru"}, Headers: map[string]string{"X-Beta": "on"}},
+		})},
+		entity.URL{ID: "sampled", Value: sampled.URL, Feeds: feed(entity.Feed{Sample: 25})},
+		entity.URL{ID: "heavy", Value: heavy.URL, Feeds: feed(entity.Feed{Group: "ab", Weight: 3})},
+		entity.URL{ID: "light", Value: light.URL, Feeds: feed(entity.Feed{Group: "ab"})},
+	)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, fanOuter.Init(ctx))
+
+	for i := 0; i < queries; i++ {
+		for _, kind := range kinds {
+			_, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "routed", Query: kind.query, Header: kind.header, Body: []byte(kind.body)})
+			require.Nil(t, err)
+		}
+	}
+	time.Sleep(1500 * time.Millisecond)
+
+	count := func(target *Target) int {
+		n := 0
+		for _, kind := range kinds {
+			n += target.Count(kind.body)
+		}
+		return n
+	}
+	total := queries * len(kinds)
+	require.Equal(t, total, count(all), "url without rules should receive all queries")
+	require.Equal(t, queries, matched.Count("ru-beta"), "url with condition should receive matched queries")
+	require.Equal(t, queries, count(matched), "url with condition should not receive other queries")
+	fmt.Printf("sampled: %v of %v, heavy: %v, light: %v\n", count(sampled), total, count(heavy), count(light))
+	require.InDelta(t, total/4, count(sampled), float64(total)/10, "url should receive sampled percentage of queries")
+	require.Equal(t, total, count(heavy)+count(light), "one url of group should receive each query")
+	require.InDelta(t, total*3/4, count(heavy), float64(total)/10, "urls of group should be picked by weight")
+}
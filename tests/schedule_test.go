@@ -0,0 +1,103 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+// Clock is clock of schedules moved by test.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func TestSchedule(t *testing.T) {
+	const timezone = "Europe/Moscow" // UTC+3 without daylight saving time
+	loc, err := time.LoadLocation(timezone)
+	require.Nil(t, err)
+	at := func(day, hour, minute int) time.Time { // day of October 2020, 12th is Monday
+		return time.Date(2020, time.October, day, hour, minute, 0, 0, loc)
+	}
+	target := NewTarget(nil)
+	defer target.Close()
+	start := func(t *testing.T, clock *Clock) (*fanouter.FanoutInteractor, context.Context, context.CancelFunc) {
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(entity.URL{ID: "u", Value: target.URL, Feeds: []entity.Feed{{
+			ID: "scheduled", Limit: "10", Schedule: &entity.Schedule{Timezone: timezone, Windows: []entity.Window{
+				{Name: "lunch", From: "12:00", To: "13:00", Limit: "5"},
+				{Name: "night", From: "23:00", To: "06:00", Days: []string{"fri"}, Limit: "40"},
+				{Name: "day", From: "09:00", To: "18:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Limit: "30"},
+			}},
+		}}}))).WithClock(clock.Now)
+		ctx, cancel := context.WithCancel(context.Background())
+		require.Nil(t, fanOuter.Init(ctx))
+		return fanOuter, ctx, cancel
+	}
+
+	t.Run("profiles", func(t *testing.T) {
+		tcases := []struct {
+			name    string
+			now     time.Time
+			profile string
+			limit   int
+		}{
+			{name: "before night window", now: at(16, 22, 59), profile: fanouter.DefaultProfile, limit: 10},
+			{name: "night window before midnight", now: at(16, 23, 0), profile: "night", limit: 40},
+			{name: "night window after midnight", now: at(17, 5, 59), profile: "night", limit: 40},
+			{name: "end of night window", now: at(17, 6, 0), profile: fanouter.DefaultProfile, limit: 10},
+			{name: "night window in timezone", now: at(16, 23, 30).UTC(), profile: "night", limit: 40},
+			{name: "night window of other day", now: at(15, 23, 30), profile: fanouter.DefaultProfile, limit: 10},
+			{name: "after midnight of other day", now: at(16, 0, 30), profile: fanouter.DefaultProfile, limit: 10},
+			{name: "weekday window", now: at(12, 9, 0), profile: "day", limit: 30},
+			{name: "end of weekday window", now: at(12, 18, 0), profile: fanouter.DefaultProfile, limit: 10},
+			{name: "weekday window on weekend", now: at(17, 10, 0), profile: fanouter.DefaultProfile, limit: 10},
+			{name: "every day window on weekday", now: at(14, 12, 30), profile: "lunch", limit: 5},
+			{name: "every day window on weekend", now: at(18, 12, 30), profile: "lunch", limit: 5},
+		}
+		clock := &Clock{}
+		fanOuter, ctx, cancel := start(t, clock)
+		defer cancel()
+		for _, tcase := range tcases {
+			t.Run(tcase.name, func(t *testing.T) {
+				clock.Set(tcase.now)
+				rate, err := fanOuter.Rate(ctx, "u")
+				require.Nil(t, err)
+				require.Equal(t, []fanouter.FeedRate{{ID: "scheduled", Profile: tcase.profile, Limit: tcase.limit, Effective: tcase.limit}}, rate.Feeds)
+			})
+		}
+	})
+
+	t.Run("limit of active profile", func(t *testing.T) {
+		const duration = 3 * time.Second
+		fanOuter, ctx, cancel := start(t, &Clock{now: at(17, 1, 0)})
+		defer cancel()
+		begin := time.Now()
+		drive(ctx, fanOuter, 60, duration, "scheduled")
+		received := target.CountSince("scheduled", begin.Add(time.Second)) - target.CountSince("scheduled", begin.Add(duration))
+		fmt.Printf("qps in night window: %.1f\n", float64(received)/(duration-time.Second).Seconds())
+		require.InDelta(t, 40*(duration-time.Second).Seconds(), received, 40*0.15*(duration-time.Second).Seconds(),
+			"limiter should send at limit of night window")
+	})
+}
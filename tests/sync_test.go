@@ -0,0 +1,149 @@
+// +build integration
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	controllers "github.com/shipa988/fanouter/internal/data/controller"
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+	"github.com/shipa988/fanouter/mocks"
+)
+
+func TestSyncFanout(t *testing.T) {
+	const deadline = 300 * time.Millisecond
+	reply := func(code int) *Target {
+		return NewTarget(func(body string, n int) int { return code })
+	}
+	slow := NewTarget(func(body string, n int) int {
+		time.Sleep(2 * deadline)
+		return http.StatusOK
+	})
+	defer slow.Close()
+
+	tcases := []struct {
+		name    string
+		targets []*Target
+		success map[string]bool // success criteria -> expected success of fanout
+		codes   []int           // expected status codes of urls, 0 for url without answer
+	}{
+		{
+			name:    "all delivered",
+			targets: []*Target{reply(http.StatusOK), reply(http.StatusCreated), reply(http.StatusOK)},
+			success: map[string]bool{entity.SuccessAll: true, entity.SuccessAny: true, entity.SuccessQuorum: true},
+			codes:   []int{http.StatusOK, http.StatusCreated, http.StatusOK},
+		},
+		{
+			name:    "majority delivered",
+			targets: []*Target{reply(http.StatusOK), reply(http.StatusOK), reply(http.StatusInternalServerError)},
+			success: map[string]bool{entity.SuccessAll: false, entity.SuccessAny: true, entity.SuccessQuorum: true},
+			codes:   []int{http.StatusOK, http.StatusOK, http.StatusInternalServerError},
+		},
+		{
+			name:    "minority delivered",
+			targets: []*Target{reply(http.StatusOK), reply(http.StatusNotFound), slow},
+			success: map[string]bool{entity.SuccessAll: false, entity.SuccessAny: true, entity.SuccessQuorum: false},
+			codes:   []int{http.StatusOK, http.StatusNotFound, 0},
+		},
+		{
+			name:    "nothing delivered",
+			targets: []*Target{reply(http.StatusBadGateway), slow},
+			success: map[string]bool{entity.SuccessAll: false, entity.SuccessAny: false, entity.SuccessQuorum: false},
+			codes:   []int{http.StatusBadGateway, 0},
+		},
+	}
+	for _, tcase := range tcases {
+		for success, want := range tcase.success {
+			t.Run(tcase.name+"/"+success, func(t *testing.T) {
+				var urls []entity.URL
+				for i, target := range tcase.targets {
+					urls = append(urls, entity.URL{ID: string(rune('a' + i)), Value: target.URL, Feeds: []entity.Feed{
+						{ID: "sync", Limit: "100", Mode: entity.ModeSync, Success: success, Deadline: int(deadline / time.Millisecond)},
+					}})
+				}
+				fanOuter := newFanouter(mocks.NewStaticRepo(params(urls...)))
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				require.Nil(t, fanOuter.Init(ctx))
+
+				start := time.Now()
+				result, err := fanOuter.Fanout(ctx, entity.Message{FeedID: "sync", Body: []byte("sync")})
+				require.Nil(t, err)
+				require.Less(t, int64(time.Since(start)), int64(deadline+deadline/2), "fanout should not wait longer than deadline")
+				require.Equal(t, want, result.Success)
+				require.Len(t, result.Results, len(urls))
+				for i, r := range result.Results {
+					require.Equal(t, urls[i].ID, r.URLID)
+					require.Equal(t, tcase.codes[i], r.Code)
+					if tcase.codes[i] == 0 {
+						require.Equal(t, fanouter.ErrDeadline, r.Error)
+					}
+				}
+			})
+		}
+	}
+	for _, tcase := range tcases {
+		for _, target := range tcase.targets {
+			if target != slow {
+				target.Close()
+			}
+		}
+	}
+
+	t.Run("http answer", func(t *testing.T) {
+		ok, failed := reply(http.StatusOK), reply(http.StatusServiceUnavailable)
+		defer ok.Close()
+		defer failed.Close()
+		fanOuter := newFanouter(mocks.NewStaticRepo(params(
+			entity.URL{ID: "ok", Value: ok.URL, Feeds: []entity.Feed{{ID: "sync", Limit: "100", Mode: entity.ModeSync}}},
+			entity.URL{ID: "failed", Value: failed.URL, Feeds: []entity.Feed{{ID: "sync", Limit: "100"}}},
+		)))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Nil(t, fanOuter.Init(ctx))
+		addr, stop := serve(t, fanOuter)
+		defer stop()
+
+		resp, err := http.Post(addr+"/feeds/sync", "text/plain", strings.NewReader("sync"))
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadGateway, resp.StatusCode, "failed fanout should be answered with 502")
+		result := entity.FanoutResult{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.False(t, result.Success)
+		require.Len(t, result.Results, 2)
+		require.Equal(t, entity.Result{URLID: "ok", Code: http.StatusOK, Latency: result.Results[0].Latency}, result.Results[0])
+		require.Equal(t, "failed", result.Results[1].URLID)
+		require.Equal(t, http.StatusServiceUnavailable, result.Results[1].Code)
+		require.NotEmpty(t, result.Results[1].Error)
+	})
+	t.Run("durable queue", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "queue")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		ok := reply(http.StatusOK)
+		defer ok.Close()
+		fanOuter := fanouter.NewFanoutInteractor(mocks.NewStaticRepo(params(
+			entity.URL{ID: "ok", Value: ok.URL, Feeds: []entity.Feed{{ID: "sync", Limit: "100", Mode: entity.ModeSync, Durable: true}}},
+		)), controllers.NewHTTPClientFabric(), limiter.NewDefaultFabrics().WithJournals(repository.NewWALFabric(dir)),
+			mocks.NewMockLogger(), mocks.NewMockMetrics())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err = fanOuter.Init(ctx)
+		_, isParamErr := errors.Cause(err).(*entity.ParamError)
+		require.Truef(t, isParamErr, "sync feed with durable queue should not be started as its results never come, got %v", err)
+	})
+}
@@ -0,0 +1,103 @@
+// +build integration
+
+package tests
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shipa988/fanouter/internal/data/repository"
+	"github.com/shipa988/fanouter/internal/domain/entity"
+	"github.com/shipa988/fanouter/internal/domain/usecase/fanouter"
+	"github.com/shipa988/fanouter/internal/domain/usecase/limiter"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *entity.FanParam {
+		return params(entity.URL{ID: "u", Value: "http://localhost/", Feeds: []entity.Feed{{ID: "a", Limit: "10"}}})
+	}
+	tcases := []struct {
+		name   string
+		change func(p *entity.FanParam)
+		paths  []string // json paths of expected problems, nil for valid parameters
+	}{
+		{
+			name:   "registered algorithm",
+			change: func(p *entity.FanParam) { p.URLs[0].Feeds[0].Algorithm = limiter.AlgorithmGCRA },
+		},
+		{
+			name:   "unknown algorithm",
+			change: func(p *entity.FanParam) { p.URLs[0].Feeds[0].Algorithm = "leaky" },
+			paths:  []string{"$.urls[0].feeds[0].algorithm"},
+		},
+		{
+			name:   "durable without queue dir",
+			change: func(p *entity.FanParam) { p.URLs[0].Feeds[0].Durable = true },
+			paths:  []string{"$.urls[0].feeds[0].durable"},
+		},
+		{
+			name: "retry out of range",
+			change: func(p *entity.FanParam) {
+				p.URLs[0].Retry = &entity.Retry{Attempts: -1, Base: 10, Jitter: 1.5, Codes: []int{503, 1000}}
+			},
+			paths: []string{"$.urls[0].retry.attempts", "$.urls[0].retry.jitter", "$.urls[0].retry.codes[1]"},
+		},
+		{
+			name:   "breaker out of range",
+			change: func(p *entity.FanParam) { p.URLs[0].Breaker = &entity.Breaker{FailureRate: 2, Probes: -1} },
+			paths:  []string{"$.urls[0].breaker.failurerate", "$.urls[0].breaker.probes"},
+		},
+		{
+			name: "adaptive out of range",
+			change: func(p *entity.FanParam) {
+				p.URLs[0].Adaptive = &entity.Adaptive{Decrease: 1.5, Increase: -0.1, Min: -1, Interval: -1}
+			},
+			paths: []string{"$.urls[0].adaptive.decrease", "$.urls[0].adaptive.increase", "$.urls[0].adaptive.min", "$.urls[0].adaptive.interval"},
+		},
+	}
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			p := valid()
+			tcase.change(p)
+			err := fanouter.Validate(p, limiter.NewDefaultFabrics())
+			if tcase.paths == nil {
+				require.Nil(t, err)
+				return
+			}
+			perr, ok := errors.Cause(err).(*entity.ParamError)
+			require.Truef(t, ok, "problems should be listed by *entity.ParamError, got %v", err)
+			var paths []string
+			for _, p := range perr.Problems {
+				paths = append(paths, p.Path)
+			}
+			require.Equal(t, tcase.paths, paths)
+		})
+	}
+
+	t.Run("durable with queue dir", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "queue")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		p := valid()
+		p.URLs[0].Feeds[0].Durable = true
+		require.Nil(t, fanouter.Validate(p, limiter.NewDefaultFabrics().WithJournals(repository.NewWALFabric(dir))))
+	})
+
+	t.Run("durable with sync mode", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "queue")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		p := valid()
+		p.URLs[0].Feeds[0].Mode = entity.ModeSync
+		p.URLs = append(p.URLs, entity.URL{ID: "v", Value: "http://localhost/", Feeds: []entity.Feed{{ID: "a", Limit: "10", Durable: true}}})
+		err = fanouter.Validate(p, limiter.NewDefaultFabrics().WithJournals(repository.NewWALFabric(dir)))
+		perr, ok := errors.Cause(err).(*entity.ParamError)
+		require.Truef(t, ok, "problems should be listed by *entity.ParamError, got %v", err)
+		require.Len(t, perr.Problems, 1)
+		require.Equal(t, "$.urls[1].feeds[0].durable", perr.Problems[0].Path, "route of sync feed should not be durable")
+	})
+}